@@ -0,0 +1,1090 @@
+// Package arctic organizes Reddit dump files (RS_/RC_ *.zst) into one
+// compressed JSONL file per subreddit and month.
+//
+// A Processor is configured through Options and does the whole run: it finds
+// the dumps in the input directory, splits them by subreddit into the output
+// directory and finally compresses the results.
+package arctic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Constants
+const (
+	chunkSize  = 50000
+	bufferSize = 10 * 1024 * 1024 // 10MB
+
+	// Only the start of lines exceeding MaxLineSize is kept in the bad line
+	// log
+	tooLongPreviewSize = 1024
+)
+
+// ErrInterrupted is returned by ProcessFile when the run was cancelled after
+// the file's buffered chunk has been flushed.
+var ErrInterrupted = errors.New("interrupted")
+
+// ErrAlreadyProcessed is returned by ProcessFile for files the progress
+// manifest lists as completed.
+var ErrAlreadyProcessed = errors.New("already processed")
+
+// StdinInput as Options.InputDir reads uncompressed JSONL from stdin instead
+// of dumps from a directory.
+const StdinInput = "-"
+
+// ErrCorruptInput is wrapped by the error ProcessFile returns when a dump
+// can't be decompressed, typically because an interrupted download left it
+// truncated.
+var ErrCorruptInput = errors.New("corrupt or truncated input")
+
+// ErrNotJSONL is wrapped by the error ProcessFile returns when the first
+// Options.NotJSONLLines lines of a file all fail to parse, so it most likely
+// holds something else than JSON lines.
+var ErrNotJSONL = errors.New("not JSONL")
+
+// ErrTimeout is wrapped by the error ProcessFile returns when a file took
+// longer than Options.Timeout.
+var ErrTimeout = errors.New("timeout")
+
+// Options configures a Processor. Start from DefaultOptions and override what
+// you need.
+type Options struct {
+	InputDir  string // a directory of dumps, a single dump, or StdinInput
+	OutputDir string
+	Month     string // output directory name for StdinInput, e.g. "2023-01"
+
+	// Files, if set, are the dumps processed instead of the ones in
+	// InputDir, which is ignored, e.g. to shard a run across machines.
+	Files []string
+
+	// NamePattern extracts the month from the names of dumps that don't follow
+	// the RS_/RC_YYYY-MM.zst convention through a capture group named
+	// "month". Names it doesn't match use the whole name without ".zst".
+	// Months not matching MonthPattern, YYYY-MM or YYYY by default, are
+	// written to "_unknown" instead, so odd names don't create stray
+	// directories.
+	NamePattern  *regexp.Regexp
+	MonthPattern *regexp.Regexp
+
+	Concurrency int // number of files processed at the same time
+
+	// CompressConcurrency is the number of output files compressed at the
+	// same time, by default the number of CPUs; 0 uses Concurrency.
+	// Processing mostly waits on reading and decoding the dumps and on
+	// writing many small files, so more workers than CPUs can pay off on
+	// slow disks, while compressing keeps a CPU busy per file and gains
+	// nothing beyond one worker per core, but may want fewer to leave room
+	// for other work.
+	CompressConcurrency int
+
+	// SerializePartitions lets only one worker at a time write to a
+	// partition, usually a month: a worker flushing a chunk holds every
+	// partition in it until all of it is written. Files of different months
+	// still run fully in parallel, while files of the same month, as with
+	// FlatBySubreddit or SingleFile, wait for each other instead of taking
+	// turns on every output file, keeping fewer files open. The decoding
+	// isn't serialized, but workers of the same month only make progress as
+	// fast as one of them can write.
+	SerializePartitions bool
+
+	Timeout time.Duration // per file, 0 disables it
+	Force   bool          // reprocess files the manifest lists as completed
+	DryRun  bool          // scan and filter, but don't write anything
+
+	// FlushOnTimeout writes the posts read before a file timed out instead
+	// of discarding them, and records the file as partial in the progress
+	// manifest. The file still fails with ErrTimeout. A rerun processes it
+	// from the start, writing those posts again, unless Incremental restarts
+	// its month.
+	FlushOnTimeout bool
+
+	// CountOnly is a DryRun that only counts the posts per subreddit as lines
+	// are read, instead of buffering them in chunks first. Fields,
+	// AddSourceMonth and CompactJSON only shape the output, so they're
+	// skipped too.
+	CountOnly bool
+
+	// Incremental adds new months to an existing output tree: dumps whose
+	// month directory already exists from an earlier run are skipped, see
+	// claimMonth. Only supported with PartitionFile. Compression merges into
+	// existing files instead of overwriting them, unless KeepJSONL.
+	Incremental bool
+
+	// WorkersPerFile decodes the lines of each file on this many goroutines
+	// instead of one, for inputs with fewer files than cores. The output
+	// order doesn't change.
+	WorkersPerFile int
+
+	// Transformers rewrite every post in turn before the field projection,
+	// see PostTransformer.
+	Transformers []PostTransformer
+
+	// Fields limits the JSONL output to these keys, in this order. Empty
+	// keeps every field of the input.
+	Fields []string
+
+	// CompactJSON removes the whitespace between the tokens of every post,
+	// which otherwise is kept as in the input. Posts a transformer returned
+	// with line breaks are always compacted, so every post stays on one line.
+	CompactJSON bool
+
+	// Sort orders every output file by created_utc once an input file is
+	// done, using an external merge sort. Only supported for JSONL.
+	Sort bool
+
+	// NameMode selects how subreddit names are sanitized for file names,
+	// NamesASCIIOnly by default.
+	NameMode NameMode
+
+	// LowercaseSubreddits lowercases subreddit names before sanitization, so
+	// "AskReddit" and "askreddit" share one file.
+	LowercaseSubreddits bool
+
+	// Partition selects the directories below the output directory,
+	// PartitionFile by default. PartitionKey selects the field the output
+	// files are named after, PartitionBySubreddit by default. Posts of
+	// deleted authors go to "_deleted".
+	Partition    Partition
+	PartitionKey PartitionKey
+
+	// FlatBySubreddit writes every subreddit to a single file across all
+	// months instead of one per month. AddSourceMonth adds the month of the
+	// dump to every post as "source_month".
+	FlatBySubreddit bool
+	AddSourceMonth  bool
+
+	// SplitByType writes submissions and comments below separate
+	// "submissions" and "comments" directories, instead of writing comments
+	// to a "comments" directory below the partition. Type, if set, only
+	// processes the dumps of that type, told apart by their RS_/RC_ prefix.
+	SplitByType bool
+	Type        DumpType
+
+	// DetectRecordType reads every dump as a mix of submissions and
+	// comments, telling each record apart by whether it has a "body" and no
+	// "title" (a comment) or not (a submission), for archives interleaving
+	// both. Type then filters records instead of dumps.
+	DetectRecordType bool
+
+	// SingleFile writes all posts of a month to one file, "_all", instead
+	// of one per subreddit, for bulk loaders that prefer few large files.
+	// A field projection always keeps the subreddit.
+	SingleFile bool
+
+	// Shard groups the output files of a month into subdirectories named
+	// after the first Shard (1 or 2) characters of the lowercased subreddit
+	// name, 0 disables it.
+	Shard int
+
+	// OutputTemplate replaces the layout of the output files with a
+	// text/template resolving to their path below the output directory, e.g.
+	// "{{.Subreddit}}/{{.Year}}/{{.Month}}.jsonl", using .Subreddit, .Month,
+	// .Year and .Type. Files several workers resolve to are shared like with
+	// FlatBySubreddit. VerifyOutput can't tell from the name which posts
+	// belong in a file then, so it doesn't check the routing.
+	OutputTemplate string
+
+	// MinPosts drops the output files written to in the run that hold fewer
+	// posts once every input file is done, 0 keeps all of them.
+	MinPosts int
+
+	// PruneEmpty removes the directories the run wrote output to that are
+	// left empty at the end, e.g. once MinPosts dropped all of their files or
+	// the Sink moved them elsewhere. Nothing the run didn't write to is
+	// touched.
+	PruneEmpty bool
+
+	// Format of the per-subreddit files. Columns selects the CSV columns and
+	// defaults to DefaultColumns.
+	Format  OutputFormat
+	Columns []string
+
+	// OutputCompression is CompressionZstd by default. CompressionLevel only
+	// applies to zstd, GzipLevel from 1 (fastest) to 9 (smallest) to gzip,
+	// 0 is gzip's default of 6.
+	OutputCompression Compression
+	CompressionLevel  zstd.EncoderLevel
+	GzipLevel         int
+	KeepJSONL         bool // keep the uncompressed files after compressing them
+
+	// OnExisting is what compression does when a file was already
+	// compressed, e.g. by an earlier run whose output only had some of the
+	// posts. OnExistingOverwrite by default.
+	OnExisting ExistingPolicy
+
+	// PreserveMtime sets the modification time of the compressed files to
+	// the time of the dumps their posts came from, to the created_utc of
+	// their newest post or to the time they're compressed, instead of
+	// leaving whatever the file system sets. Merged files keep the time of
+	// the existing file if it's newer. Empty disables it.
+	PreserveMtime MtimeSource
+
+	// Dictionary is a zstd dictionary to compress the output with, and
+	// TrainDictionary trains one on the output instead. Either is saved to
+	// the output directory as "_zstd_dictionary", which later runs keep
+	// using and which is needed to decompress the files.
+	Dictionary      []byte
+	TrainDictionary bool
+
+	// FileMode and DirMode are the permissions of the files and directories
+	// created below the output directory, 0644 and 0755 by default. The
+	// process umask is applied to them as usual, so e.g. group-writable
+	// output needs a umask of 002 as well.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
+	// Sink receives the compressed output files, a LocalSink writing to
+	// OutputDir by default. See Sink for what stays in the output directory.
+	// Existing files for OnExisting are only looked for in the output
+	// directory.
+	Sink Sink
+
+	// SeekIndex compresses JSONL output in frames of about 1 MB and writes an
+	// .idx file next to every .zst, so single records can be read with
+	// OpenIndexed without decompressing the whole file.
+	SeekIndex bool
+
+	// Limit stops reading each input after this many lines and Sample only
+	// keeps every Sample-th line, for quick trial runs. 0 disables either.
+	Limit  int64
+	Sample int64
+
+	// Strict also treats lines as unparseable whose subreddit isn't a
+	// non-empty string or whose created_utc isn't a plausible number.
+	Strict bool
+
+	// MoveCorrupt renames dumps that turn out to be corrupt or truncated to
+	// <name>.corrupt, so later runs don't pick them up again.
+	MoveCorrupt bool
+
+	MaxErrors   int64 // unparseable lines per file before aborting it, 0 is unlimited
+	MaxLineSize int   // longer lines are skipped, 0 is unlimited
+
+	// RecordDelimiter splits the dumps into records, one per line by
+	// default. Records split at another character, or at the end of every
+	// JSON value with RecordDelimiterJSON, may span lines: they are written
+	// compacted, with literal newlines in strings escaped. Lines, e.g. in
+	// Limit or the bad line log, count records then.
+	RecordDelimiter RecordDelimiter
+
+	// The zstd decoders of the dumps use DecoderConcurrency goroutines, by
+	// default 4 or the number of CPUs if fewer; 1 decodes synchronously.
+	// DecoderMaxWindow is the largest window accepted, 512 MiB by default;
+	// dumps compressed with --long=31 need 2 GiB. DecoderMaxMemory caps the
+	// window further, 64 GiB by default. 0 keeps a default.
+	DecoderConcurrency int
+	DecoderMaxWindow   uint64
+	DecoderMaxMemory   uint64
+
+	// NotJSONLLines aborts a file with ErrNotJSONL once this many lines
+	// failed to parse before any line did, 0 disables the check.
+	NotJSONLLines int64
+
+	// MaxReadMBps throttles the decompressed data each worker reads to this
+	// many MB per second, and MaxTotalReadMBps the data all workers read
+	// together. 0 is unlimited.
+	MaxReadMBps      float64
+	MaxTotalReadMBps float64
+
+	// ChunkBytes flushes the buffered posts once their JSON adds up to this
+	// many bytes, even before chunkSize posts are reached. 0 only flushes by
+	// count.
+	ChunkBytes int64
+
+	// TmpDir enables moving buffered posts out of memory into a temporary
+	// file there once they add up to SpillBytes, until the chunk is flushed.
+	// Peak memory then no longer depends on the chunk size, except for the
+	// posts of the largest subreddit in a chunk, which are read back at once.
+	TmpDir     string
+	SpillBytes int64
+
+	// MaxFileBytes caps the uncompressed size of the output files. Posts
+	// that would exceed it go to numbered parts, "<subreddit>.part0001.jsonl"
+	// and so on, compressed separately; a single larger post gets a part of
+	// its own. 0 doesn't limit the size.
+	MaxFileBytes int64
+
+	// RetryAttempts is the number of tries for opening and writing files
+	// before a transient error (EAGAIN, timeouts, ...) fails the file, 1
+	// disables retrying. RetryBackoff is the wait before the first retry and
+	// doubles for every further one.
+	RetryAttempts int
+	RetryBackoff  time.Duration
+
+	// MaxOpenFiles bounds the output files each worker keeps open; the least
+	// recently used one is closed when the limit is reached. It's lowered to
+	// fit all workers within the open file limit of the process.
+	MaxOpenFiles int
+
+	// Posts created before After or at/after Before are dropped. Zero values
+	// disable the bound. Posts without a created_utc are kept unless
+	// DropUndated is set.
+	After       time.Time
+	Before      time.Time
+	DropUndated bool
+
+	// DropDeleted drops posts whose author, selftext or body is one of
+	// DeletedMarkers, which defaults to DefaultDeletedMarkers.
+	DropDeleted    bool
+	DeletedMarkers []string
+
+	// Dedupe drops posts whose id was already seen in the same input file,
+	// remembering the last DedupeWindow ids.
+	Dedupe       bool
+	DedupeWindow int
+
+	// FastDecode reads the fields routing needs with a single-pass scanner
+	// instead of encoding/json, which is several times faster. Lines the
+	// scanner can't handle are decoded with encoding/json, so the output is
+	// the same.
+	FastDecode bool
+
+	// Filter drops the posts it doesn't match, comparing the fields of the
+	// input before any Transformers.
+	Filter *Filter
+
+	// Subreddit names, matched case-insensitively before sanitization. An
+	// empty Include list allows every subreddit.
+	Include []string
+	Exclude []string
+
+	// FileProgress prints a progress line per input file, TotalProgress a
+	// single line for the whole run with an ETA based on the overall
+	// throughput. With both, only the final line of every file is printed.
+	FileProgress  bool
+	TotalProgress bool
+
+	// ProgressInterval is how often the progress lines are updated. 0 picks
+	// 100ms when stdout is a terminal and 10s otherwise, where every update
+	// is a line of its own. Quiet prints only the final line of every file.
+	ProgressInterval time.Duration
+	Quiet            bool
+
+	// RowEstimate adds the progress in rows against an estimated number of
+	// rows to the file progress, which refines as the file is read.
+	RowEstimate bool
+
+	// PauseFile pauses processing while a file exists at this path: the
+	// workers finish the chunk they are writing and no new files are
+	// started until it is removed. Paused time doesn't count towards the
+	// Timeout.
+	PauseFile string
+
+	// RunReport writes the outcome, counters, timing and errors of every
+	// file to outputDir/run-report.json at the end of Run.
+	RunReport bool
+
+	// ProfileTiming records how long decoding every line and writing every
+	// chunk takes, see Processor.Timing.
+	ProfileTiming bool
+
+	// Logger receives everything except the live progress lines, which are
+	// written to stdout. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// DefaultOptions returns the options used when nothing else is configured.
+func DefaultOptions() Options {
+	return Options{
+		Concurrency:         runtime.NumCPU(),
+		CompressConcurrency: runtime.NumCPU(),
+		Format:              FormatJSONL,
+		OutputCompression:   CompressionZstd,
+		CompressionLevel:    zstd.SpeedDefault,
+		MaxLineSize:         256 * 1024 * 1024,
+		MaxOpenFiles:        256,
+		NotJSONLLines:       100,
+		ChunkBytes:          256 * 1024 * 1024,
+		SpillBytes:          32 * 1024 * 1024,
+		FileMode:            defaultPermissions.file,
+		DirMode:             defaultPermissions.dir,
+		RetryAttempts:       3,
+		RetryBackoff:        100 * time.Millisecond,
+		NameMode:            NamesASCIIOnly,
+		Partition:           PartitionFile,
+		DedupeWindow:        1000000,
+		FastDecode:          true,
+		FileProgress:        true,
+	}
+}
+
+// Processor runs the organize pipeline. It is safe to call ProcessFile from
+// multiple goroutines.
+type Processor struct {
+	opts      Options
+	include   subredditSet
+	exclude   subredditSet
+	manifest  *progressManifest
+	names     *subredditNames
+	index     *outputIndex
+	fileLocks *pathLocks
+	dirLocks  *pathLocks // per partition, for SerializePartitions
+	sorted    *sortRuns  // nil unless Sort is set
+	parts     *partCounter
+	monthMu   sync.Mutex // serializes claimMonth
+	stats     *RunStats
+	metrics   *Metrics
+	total     *totalProgress // nil unless TotalProgress is set
+	timing    *timingProfile // nil unless ProfileTiming is set
+	dict      []byte         // zstd dictionary of the output, if any
+	pause     *pauseControl  // nil unless PauseFile is set
+	report    *runReport     // nil unless RunReport is set
+	readLimit *tokenBucket   // nil unless MaxTotalReadMBps is set
+	touched   *pathSet       // nil unless PruneEmpty is set
+	written   *pathSet       // nil unless MinPosts is set
+	cpu       *cpuClock      // nil where the CPU time isn't known
+	mtimes    *fileTimes     // nil unless PreserveMtime needs them
+	retry     retryPolicy
+	log       *slog.Logger
+
+	template *template.Template // nil unless OutputTemplate is set
+}
+
+// normalizeOptions validates opts and fills in the defaults of the options
+// that aren't about the input and output directories, for NewProcessor and
+// ForEachPost.
+func normalizeOptions(opts Options) (Options, error) {
+	if opts.Concurrency < 1 {
+		return opts, fmt.Errorf("invalid concurrency %d: must be at least 1", opts.Concurrency)
+	}
+	if opts.CompressConcurrency < 0 {
+		return opts, fmt.Errorf("invalid compress concurrency %d: must not be negative", opts.CompressConcurrency)
+	}
+	if opts.CompressConcurrency == 0 {
+		opts.CompressConcurrency = opts.Concurrency
+	}
+	if opts.WorkersPerFile < 0 {
+		return opts, fmt.Errorf("invalid workers per file %d: must not be negative", opts.WorkersPerFile)
+	}
+	if opts.Dedupe && opts.DedupeWindow < 1 {
+		return opts, fmt.Errorf("invalid dedupe window %d: must be at least 1", opts.DedupeWindow)
+	}
+	if opts.Shard < 0 || opts.Shard > 2 {
+		return opts, fmt.Errorf("invalid shard %d: must be 0, 1 or 2", opts.Shard)
+	}
+	if opts.Limit < 0 || opts.Sample < 0 {
+		return opts, fmt.Errorf("invalid limit %d or sample %d: must not be negative", opts.Limit, opts.Sample)
+	}
+	if opts.MaxFileBytes < 0 {
+		return opts, fmt.Errorf("invalid max file bytes %d: must not be negative", opts.MaxFileBytes)
+	}
+	if opts.MaxReadMBps < 0 || opts.MaxTotalReadMBps < 0 {
+		return opts, fmt.Errorf("invalid read limit %g or total read limit %g: must not be negative", opts.MaxReadMBps, opts.MaxTotalReadMBps)
+	}
+	if opts.ChunkBytes < 0 {
+		return opts, fmt.Errorf("invalid chunk bytes %d: must not be negative", opts.ChunkBytes)
+	}
+	if opts.TmpDir != "" {
+		if opts.SpillBytes < 1 {
+			return opts, fmt.Errorf("invalid spill bytes %d: must be at least 1", opts.SpillBytes)
+		}
+		if info, err := os.Stat(opts.TmpDir); err != nil || !info.IsDir() {
+			return opts, fmt.Errorf("invalid temporary directory %s: not a directory", opts.TmpDir)
+		}
+	}
+	if opts.RetryAttempts < 1 {
+		return opts, fmt.Errorf("invalid retry attempts %d: must be at least 1", opts.RetryAttempts)
+	}
+	if opts.MinPosts < 0 {
+		return opts, fmt.Errorf("invalid min posts %d: must not be negative", opts.MinPosts)
+	}
+	decoder, err := zstd.NewReader(nil, dumpDecoderOptions(opts)...)
+	if err != nil {
+		return opts, fmt.Errorf("invalid zstd decoder options: %v", err)
+	}
+	decoder.Close()
+	if opts.NotJSONLLines < 0 {
+		return opts, fmt.Errorf("invalid not JSONL lines %d: must not be negative", opts.NotJSONLLines)
+	}
+	if opts.MaxOpenFiles < 1 {
+		return opts, fmt.Errorf("invalid max open files %d: must be at least 1", opts.MaxOpenFiles)
+	}
+	switch opts.Format {
+	case "":
+		opts.Format = FormatJSONL
+	case FormatJSONL, FormatCSV:
+	default:
+		return opts, fmt.Errorf("invalid output format %q: must be %s or %s", opts.Format, FormatJSONL, FormatCSV)
+	}
+	if opts.Format == FormatCSV && len(opts.Columns) == 0 {
+		opts.Columns = DefaultColumns
+	}
+	if opts.CountOnly {
+		opts.DryRun = true
+	}
+	if opts.DropDeleted && len(opts.DeletedMarkers) == 0 {
+		opts.DeletedMarkers = DefaultDeletedMarkers
+	}
+	switch opts.NameMode {
+	case "":
+		opts.NameMode = NamesASCIIOnly
+	case NamesASCIIOnly, NamesUnicodeSafe:
+	default:
+		return opts, fmt.Errorf("invalid name mode %q: must be %s or %s", opts.NameMode, NamesASCIIOnly, NamesUnicodeSafe)
+	}
+	if len(opts.Fields) > 0 && opts.Format != FormatJSONL {
+		return opts, fmt.Errorf("field projection is only supported for %s output, use the columns for %s", FormatJSONL, FormatCSV)
+	}
+	if opts.SingleFile {
+		if opts.Shard > 0 || (opts.PartitionKey != "" && opts.PartitionKey != PartitionBySubreddit) {
+			return opts, errors.New("single-file output is not supported with sharding or a partition key")
+		}
+		if len(opts.Fields) > 0 && !slices.Contains(opts.Fields, "subreddit") {
+			opts.Fields = append(slices.Clone(opts.Fields), "subreddit")
+		}
+	}
+	if opts.OutputCompression == "" {
+		opts.OutputCompression = CompressionZstd
+	}
+	switch opts.OutputCompression {
+	case CompressionZstd, CompressionGzip, CompressionNone:
+	default:
+		return opts, fmt.Errorf("invalid output compression %q: must be %s, %s or %s",
+			opts.OutputCompression, CompressionZstd, CompressionGzip, CompressionNone)
+	}
+	if _, _, err := opts.RecordDelimiter.splitter(); err != nil {
+		return opts, err
+	}
+	switch opts.PreserveMtime {
+	case "", MtimeInputFile, MtimeMaxCreatedUTC, MtimeNow:
+	default:
+		return opts, fmt.Errorf("invalid mtime source %q: must be %s, %s or %s",
+			opts.PreserveMtime, MtimeInputFile, MtimeMaxCreatedUTC, MtimeNow)
+	}
+	switch opts.OnExisting {
+	case "":
+		opts.OnExisting = OnExistingOverwrite
+	case OnExistingOverwrite, OnExistingSkip:
+	case OnExistingMerge:
+		if opts.KeepJSONL {
+			// The kept files already hold what was compressed before
+			return opts, errors.New("merging into existing compressed files is not supported when keeping the uncompressed files")
+		}
+	default:
+		return opts, fmt.Errorf("invalid existing file policy %q: must be %s, %s or %s",
+			opts.OnExisting, OnExistingOverwrite, OnExistingSkip, OnExistingMerge)
+	}
+	if opts.Incremental && opts.OnExisting == OnExistingOverwrite && !opts.KeepJSONL {
+		// Another dump of a month already compressed would replace its files
+		opts.OnExisting = OnExistingMerge
+	}
+	if opts.SeekIndex && (opts.Format != FormatJSONL || opts.OutputCompression != CompressionZstd) {
+		return opts, fmt.Errorf("seek indexes are only supported for %s output compressed with %s", FormatJSONL, CompressionZstd)
+	}
+	if opts.Sort && opts.Format != FormatJSONL {
+		return opts, fmt.Errorf("sorting is only supported for %s output", FormatJSONL)
+	}
+	switch opts.Partition {
+	case "":
+		opts.Partition = PartitionFile
+	case PartitionFile, PartitionYear, PartitionMonth, PartitionDay:
+	default:
+		return opts, fmt.Errorf("invalid partition %q: must be %s, %s, %s or %s",
+			opts.Partition, PartitionFile, PartitionYear, PartitionMonth, PartitionDay)
+	}
+	switch opts.Type {
+	case "", DumpSubmissions, DumpComments:
+	default:
+		return opts, fmt.Errorf("invalid type %q: must be %s or %s", opts.Type, DumpSubmissions, DumpComments)
+	}
+	if opts.DetectRecordType && opts.Incremental && opts.SplitByType {
+		return opts, errors.New("incremental mode can't split mixed dumps by type")
+	}
+	switch opts.PartitionKey {
+	case "":
+		opts.PartitionKey = PartitionBySubreddit
+	case PartitionBySubreddit, PartitionByAuthor, PartitionByDomain:
+	default:
+		return opts, fmt.Errorf("invalid partition key %q: must be %s, %s or %s",
+			opts.PartitionKey, PartitionBySubreddit, PartitionByAuthor, PartitionByDomain)
+	}
+	if opts.Partition != PartitionFile && opts.FlatBySubreddit {
+		return opts, errors.New("partitioning is not supported with flat-by-subreddit output")
+	}
+	if opts.OutputTemplate != "" {
+		if opts.Shard > 0 || opts.FlatBySubreddit || opts.SplitByType || opts.Incremental || opts.Sort {
+			return opts, errors.New("an output template is not supported with sharding, flat-by-subreddit, split types, incremental mode or sorting")
+		}
+		if _, err := parseOutputTemplate(opts.OutputTemplate, opts.Format); err != nil {
+			return opts, err
+		}
+	}
+	if opts.Sort && (opts.FlatBySubreddit || opts.SingleFile) {
+		// Files shared by concurrent workers can't be merged in place
+		return opts, errors.New("sorting is not supported with flat-by-subreddit or single-file output")
+	}
+	if opts.CompressionLevel == 0 {
+		opts.CompressionLevel = zstd.SpeedDefault
+	}
+	if opts.GzipLevel < 0 || opts.GzipLevel > gzip.BestCompression {
+		return opts, fmt.Errorf("invalid gzip level %d: must be between %d and %d, or 0 for the default", opts.GzipLevel, gzip.BestSpeed, gzip.BestCompression)
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.Incremental && (opts.Partition != PartitionFile || opts.FlatBySubreddit) {
+		return opts, errors.New("incremental mode is only supported with one output directory per dump month")
+	}
+	if opts.MonthPattern == nil {
+		opts.MonthPattern = defaultMonthPattern
+	}
+	if opts.NamePattern != nil && opts.NamePattern.SubexpIndex("month") < 0 {
+		return opts, fmt.Errorf("invalid name pattern %q: must have a capture group named month", opts.NamePattern)
+	}
+	if !opts.After.IsZero() && !opts.Before.IsZero() && !opts.After.Before(opts.Before) {
+		return opts, fmt.Errorf("invalid date range: after (%s) must be earlier than before (%s)",
+			opts.After.Format(time.RFC3339), opts.Before.Format(time.RFC3339))
+	}
+	if opts.ProgressInterval < 0 {
+		return opts, fmt.Errorf("invalid progress interval %s: must not be negative", opts.ProgressInterval)
+	}
+	if opts.ProgressInterval == 0 {
+		opts.ProgressInterval = defaultProgressInterval()
+	}
+	return opts, nil
+}
+
+// NewProcessor validates opts and loads the progress manifest from the output
+// directory.
+func NewProcessor(opts Options) (*Processor, error) {
+	if opts.InputDir != StdinInput {
+		opts.InputDir = filepath.FromSlash(opts.InputDir)
+	}
+	opts.OutputDir = filepath.FromSlash(opts.OutputDir)
+
+	opts, err := normalizeOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	var tmpl *template.Template
+	if opts.OutputTemplate != "" {
+		if tmpl, err = parseOutputTemplate(opts.OutputTemplate, opts.Format); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.Files) > 0 {
+		seen := make(map[string]bool, len(opts.Files))
+		for _, file := range opts.Files {
+			if err := validateInputFile(file); err != nil {
+				return nil, fmt.Errorf("invalid input file: %v", err)
+			}
+			abs, err := filepath.Abs(file)
+			if err != nil {
+				return nil, fmt.Errorf("invalid input file: error resolving %s: %v", file, err)
+			}
+			if seen[abs] {
+				return nil, fmt.Errorf("invalid input file: %s is listed twice", file)
+			}
+			seen[abs] = true
+		}
+	} else if opts.InputDir == StdinInput {
+		if opts.Month == "" || opts.Month == "." || opts.Month == ".." || strings.ContainsAny(opts.Month, `/\`) {
+			return nil, fmt.Errorf("invalid month %q: reading from stdin needs a month like 2023-01 to name the output directory", opts.Month)
+		}
+	} else if err := validateInputDir(opts.InputDir); err != nil {
+		return nil, fmt.Errorf("invalid input: %v", err)
+	} else if sameDir(opts.InputDir, opts.OutputDir) {
+		return nil, fmt.Errorf("invalid output directory %s: it is the input directory, use a subdirectory or another directory", opts.OutputDir)
+	}
+
+	opts.MaxOpenFiles = capOpenFiles(opts.MaxOpenFiles, opts.Concurrency, opts.Logger)
+
+	if opts.FileMode == 0 {
+		opts.FileMode = defaultPermissions.file
+	}
+	if opts.DirMode == 0 {
+		opts.DirMode = defaultPermissions.dir
+	}
+	if opts.FileMode&^os.ModePerm != 0 || opts.DirMode&^os.ModePerm != 0 {
+		return nil, fmt.Errorf("invalid file mode %o or directory mode %o: only permission bits are allowed", opts.FileMode, opts.DirMode)
+	}
+	perm := permissions{file: opts.FileMode, dir: opts.DirMode}
+	if opts.Sink == nil {
+		opts.Sink = LocalSink{Dir: opts.OutputDir, FileMode: opts.FileMode, DirMode: opts.DirMode}
+	}
+
+	manifest, err := loadProgressManifest(opts.OutputDir, perm)
+	if err != nil {
+		return nil, fmt.Errorf("error loading progress manifest: %v", err)
+	}
+	names, err := loadSubredditNames(opts.OutputDir, opts.NameMode, opts.LowercaseSubreddits, perm)
+	if err != nil {
+		return nil, fmt.Errorf("error loading subreddit names: %v", err)
+	}
+	index, err := loadOutputIndex(opts.OutputDir, perm)
+	if err != nil {
+		return nil, fmt.Errorf("error loading index: %v", err)
+	}
+	dict, err := LoadDictionary(opts.OutputDir)
+	if err != nil {
+		return nil, err
+	}
+	if dict != nil && opts.Dictionary != nil && !bytes.Equal(dict, opts.Dictionary) {
+		return nil, fmt.Errorf("the output directory is already compressed with another dictionary, %s", dictionaryName)
+	}
+
+	p := &Processor{
+		opts:      opts,
+		template:  tmpl,
+		include:   newSubredditSet(opts.Include),
+		exclude:   newSubredditSet(opts.Exclude),
+		manifest:  manifest,
+		names:     names,
+		index:     index,
+		dict:      dict,
+		fileLocks: newPathLocks(),
+		dirLocks:  newPathLocks(),
+		parts:     newPartCounter(opts.Format),
+		stats:     newRunStats(),
+		metrics:   newMetrics(),
+		cpu:       newCPUClock(),
+		log:       opts.Logger,
+		retry:     retryPolicy{attempts: opts.RetryAttempts, backoff: opts.RetryBackoff, log: opts.Logger},
+	}
+	if opts.ProfileTiming {
+		p.timing = &timingProfile{}
+	}
+	if opts.MaxTotalReadMBps > 0 {
+		p.readLimit = newTokenBucket(opts.MaxTotalReadMBps)
+	}
+	if opts.RunReport {
+		p.report = newRunReport()
+	}
+	if opts.Sort {
+		p.sorted = newSortRuns()
+	}
+	if opts.PruneEmpty {
+		p.touched = newPathSet()
+	}
+	if opts.MinPosts > 0 {
+		p.written = newPathSet()
+	}
+	if opts.PreserveMtime == MtimeInputFile || opts.PreserveMtime == MtimeMaxCreatedUTC {
+		p.mtimes = newFileTimes()
+	}
+	if opts.PauseFile != "" {
+		p.pause = newPauseControl(opts.PauseFile, opts.Logger)
+	}
+	if opts.TotalProgress {
+		p.total = newTotalProgress(p.pause)
+	}
+	return p, nil
+}
+
+// Options returns the normalized options the processor runs with.
+func (p *Processor) Options() Options {
+	return p.opts
+}
+
+// Stats returns the counters collected so far.
+func (p *Processor) Stats() *RunStats {
+	return p.stats
+}
+
+// Metrics returns the live counters of the processor, which can be served
+// over HTTP while it runs.
+func (p *Processor) Metrics() *Metrics {
+	return p.metrics
+}
+
+// Result summarizes what happened to the input files of a run.
+type Result struct {
+	Files       int   `json:"files"`
+	Completed   int64 `json:"completed"`
+	Interrupted int64 `json:"interrupted"`
+	Failed      int64 `json:"failed"`
+	Skipped     int64 `json:"skipped"`
+
+	// Cancelled is set when the context was cancelled before the run was
+	// done. Compression is skipped, or stopped if it already started, in
+	// that case.
+	Cancelled bool `json:"cancelled"`
+
+	// UnreadablePaths counts the entries below the input directory that
+	// couldn't be read and were skipped when looking for dumps.
+	UnreadablePaths int `json:"unreadable_paths"`
+}
+
+// NotStarted returns the number of files that were never picked up because
+// the run was cancelled.
+func (r Result) NotStarted() int64 {
+	return int64(r.Files) - r.Completed - r.Interrupted - r.Failed - r.Skipped
+}
+
+// Run processes every dump in the input directory, or the single input dump,
+// and compresses the output. Cancelling ctx lets the active files flush what
+// they have buffered and keeps new files from being started. Errors of
+// individual files are logged and counted in the result; the returned error
+// is reserved for failures of the run as a whole. With Options.RunReport,
+// the outcome of every file is written to the output directory at the end.
+func (p *Processor) Run(ctx context.Context) (Result, error) {
+	stopSampling := sampleMemory()
+	defer func() { p.stats.setMemory(stopSampling()) }()
+
+	result, err := p.run(ctx)
+	if p.report != nil && !p.opts.DryRun {
+		if err := p.report.write(p.opts.OutputDir, result, err, p.stats, p.perm()); err != nil {
+			p.log.Error("error writing run report", "err", err)
+		}
+	}
+	return result, err
+}
+
+func (p *Processor) run(ctx context.Context) (Result, error) {
+	files := []string{StdinInput}
+	warnings := 0
+	if len(p.opts.Files) > 0 {
+		files = p.opts.Files
+	} else if p.opts.InputDir != StdinInput {
+		var err error
+		files, warnings, err = p.getFiles(p.opts.InputDir)
+		if err != nil {
+			return Result{}, fmt.Errorf("error getting files: %v", err)
+		}
+	}
+
+	if p.opts.Type != "" && !p.opts.DetectRecordType {
+		files = slices.DeleteFunc(files, func(file string) bool {
+			kind, _ := parseDumpFilename(filepath.Base(file), p.opts.NamePattern)
+			return kind.dumpType() != p.opts.Type
+		})
+	}
+
+	result := p.processFiles(ctx, files)
+	result.UnreadablePaths = warnings
+	if result.Cancelled || p.opts.DryRun {
+		return result, nil
+	}
+	defer func() {
+		if err := p.index.save(); err != nil {
+			p.log.Error("error saving index", "err", err)
+		}
+	}()
+
+	if p.opts.MinPosts > 0 {
+		dropped, err := p.dropSmallSubreddits()
+		p.stats.setDropped(dropped)
+		if err != nil {
+			return result, fmt.Errorf("error dropping small subreddits: %v", err)
+		}
+		p.log.Info("dropped subreddits below minimum posts", "min_posts", p.opts.MinPosts, "files", dropped)
+	}
+
+	if p.opts.OutputCompression == CompressionNone {
+		p.log.Info("processing complete, leaving the output uncompressed")
+		return result, p.pruneEmptyDirs()
+	}
+	p.log.Info("processing complete, compressing output files", "compression", p.opts.OutputCompression)
+	err := p.CompressOutputFiles(ctx)
+	if errors.Is(err, ErrInterrupted) {
+		result.Cancelled = true
+		return result, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("errors while compressing output files:\n%v", err)
+	}
+	return result, p.pruneEmptyDirs()
+}
+
+func (p *Processor) processFiles(ctx context.Context, files []string) Result {
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, p.opts.Concurrency) // Limit concurrent file processing
+	var completed, interrupted, failed, skipped atomic.Int64
+	if p.report != nil {
+		p.report.addFiles(files)
+	}
+
+	if p.total != nil {
+		p.total.reset(files)
+		if p.opts.Quiet {
+			defer p.total.LogProgress("\n")
+		} else {
+			stop := p.total.start(p.opts.ProgressInterval)
+			defer stop()
+		}
+	}
+
+	for _, file := range files {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if p.pause != nil {
+			p.pause.wait(ctx)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(file string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			p.metrics.activeWorkers.Add(1)
+			defer p.metrics.activeWorkers.Add(-1)
+			start := time.Now()
+			var err error
+			if file == StdinInput {
+				err = p.ProcessReader(ctx, os.Stdin, "stdin", p.opts.Month)
+			} else {
+				err = p.ProcessFile(ctx, file)
+			}
+			switch {
+			case err == nil:
+				completed.Add(1)
+				p.metrics.filesCompleted.Add(1)
+			case errors.Is(err, ErrInterrupted):
+				interrupted.Add(1)
+				p.metrics.filesInterrupted.Add(1)
+			case errors.Is(err, ErrCorruptInput):
+				failed.Add(1)
+				p.metrics.filesFailed.Add(1)
+				p.log.Error("corrupt/truncated input", "path", file, "err", err)
+				if p.opts.MoveCorrupt {
+					p.moveCorrupt(file)
+				}
+			case errors.Is(err, ErrAlreadyProcessed):
+				skipped.Add(1)
+				p.metrics.filesSkipped.Add(1)
+				p.log.Info("skipping already processed file", "path", file)
+			default:
+				failed.Add(1)
+				p.metrics.filesFailed.Add(1)
+				p.log.Error("error processing file", "path", file, "err", err)
+			}
+			if p.report != nil {
+				p.report.finishFile(file, fileStatus(err), err, start)
+			}
+		}(file)
+	}
+
+	wg.Wait()
+	return Result{
+		Files:       len(files),
+		Completed:   completed.Load(),
+		Interrupted: interrupted.Load(),
+		Failed:      failed.Load(),
+		Skipped:     skipped.Load(),
+		Cancelled:   ctx.Err() != nil,
+	}
+}
+
+// relCompressedPath returns the path of the compressed version of an
+// uncompressed output file, relative to the output directory, as used in the
+// index.
+func (p *Processor) relCompressedPath(path string) string {
+	rel, err := filepath.Rel(p.opts.OutputDir, p.opts.OutputCompression.compressedName(path, p.opts.Format))
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// moveCorrupt renames a corrupt dump so it no longer has the .zst suffix.
+func (p *Processor) moveCorrupt(path string) {
+	target := path + ".corrupt"
+	if err := os.Rename(path, target); err != nil {
+		p.log.Error("error moving corrupt input aside", "path", path, "err", err)
+		return
+	}
+	p.log.Warn("moved corrupt input aside", "path", path, "to", target)
+}
+
+// Utility functions
+func validateInputDir(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error accessing %s: %v", path, err)
+	}
+	if !info.IsDir() {
+		if !info.Mode().IsRegular() || !strings.HasSuffix(path, ".zst") {
+			return fmt.Errorf("%s is neither a directory nor a .zst file", path)
+		}
+		return nil
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", path, err)
+	}
+	defer dir.Close()
+	if _, err := dir.Readdirnames(1); err != nil && err != io.EOF {
+		return fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return nil
+}
+
+// validateInputFile checks that the dump at path can be opened.
+func validateInputFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error accessing %s: %v", path, err)
+	}
+	if !info.Mode().IsRegular() || !strings.HasSuffix(path, ".zst") {
+		return fmt.Errorf("%s is not a .zst file", path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", path, err)
+	}
+	return file.Close()
+}
+
+// sameDir reports whether a and b resolve to the same absolute path.
+func sameDir(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// getFiles lists the dumps below root, or just root if it is a dump. Entries
+// that can't be read, such as subdirectories without permission, are logged
+// and skipped; only an inaccessible root is an error. It returns the number
+// of skipped entries. An output directory inside root is skipped, so the
+// organized files of an earlier run aren't picked up as dumps.
+func (p *Processor) getFiles(root string) ([]string, int, error) {
+	var files []string
+	warnings := 0
+	outputDir, err := filepath.Abs(p.opts.OutputDir)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error resolving output directory %s: %v", p.opts.OutputDir, err)
+	}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			p.log.Warn("skipping unreadable path", "path", path, "err", err)
+			warnings++
+			return nil
+		}
+		if info.IsDir() {
+			if abs, err := filepath.Abs(path); err == nil && abs == outputDir {
+				p.log.Debug("skipping output directory inside the input directory", "path", path)
+				return filepath.SkipDir
+			}
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".zst") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, warnings, err
+}
@@ -0,0 +1,74 @@
+package arctic
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestGetFilesSkipsUnreadablePaths(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions aren't enforced for root")
+	}
+	p := newTestProcessor(t, DefaultOptions())
+	root := p.opts.InputDir
+	readable := writeDump(t, root, filepath.Join("readable", "RS_2023-01.zst"), post("golang", "a", 1672531200))
+	top := writeDump(t, root, "RC_2023-01.zst")
+	writeDump(t, root, filepath.Join("locked", "RS_2023-02.zst"))
+	locked := filepath.Join(root, "locked")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0755)
+
+	files, skipped, err := p.getFiles(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(files)
+	if want := []string{top, readable}; !slices.Equal(files, want) {
+		t.Errorf("got files %v, want %v", files, want)
+	}
+	if skipped != 1 {
+		t.Errorf("got %d skipped paths, want 1", skipped)
+	}
+}
+
+func TestGetFilesInaccessibleRoot(t *testing.T) {
+	p := newTestProcessor(t, DefaultOptions())
+	if _, _, err := p.getFiles(filepath.Join(p.opts.InputDir, "missing")); err == nil {
+		t.Error("a missing root wasn't an error")
+	}
+}
+
+func TestGetFilesSkipsNestedOutputDir(t *testing.T) {
+	root := t.TempDir()
+	opts := DefaultOptions()
+	opts.InputDir = root
+	opts.OutputDir = filepath.Join(root, "organized")
+	p := newTestProcessor(t, opts)
+
+	dump := writeDump(t, root, "RS_2023-01.zst", post("golang", "a", 1672531200))
+	nested := writeDump(t, root, filepath.Join("older", "RS_2022-12.zst"))
+	writeDump(t, opts.OutputDir, filepath.Join("2023-01", "golang.jsonl.zst"), post("golang", "a", 1672531200))
+
+	files, _, err := p.getFiles(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(files)
+	if want := []string{dump, nested}; !slices.Equal(files, want) {
+		t.Errorf("got files %v, want %v", files, want)
+	}
+}
+
+func TestNewProcessorRejectsOutputDirEqualToInputDir(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultOptions()
+	opts.InputDir = dir
+	opts.OutputDir = dir
+	if _, err := NewProcessor(opts); err == nil {
+		t.Error("the input directory was accepted as the output directory")
+	}
+}
@@ -0,0 +1,75 @@
+package arctic
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const errorsDirName = "_errors"
+
+// badLineLog collects the lines of an input file that failed to parse into
+// outputDir/_errors/<file>.badlines. Every entry is a JSON object holding the
+// line number, the parse error and the raw line. The file is only created
+// once the first bad line is recorded, and never in dry-run mode.
+type badLineLog struct {
+	path   string
+	dryRun bool
+	perm   permissions
+	file   *os.File
+	writer *bufio.Writer
+	count  int64
+}
+
+type badLine struct {
+	Line  int64  `json:"line"`
+	Error string `json:"error"`
+	Raw   string `json:"raw"`
+}
+
+func newBadLineLog(outputDir, inputPath string, dryRun bool, perm permissions) *badLineLog {
+	name := strings.TrimSuffix(filepath.Base(inputPath), ".zst") + ".badlines"
+	return &badLineLog{path: filepath.Join(outputDir, errorsDirName, name), dryRun: dryRun, perm: perm}
+}
+
+func (l *badLineLog) record(lineNumber int64, line []byte, parseErr error) error {
+	if l.dryRun {
+		l.count++
+		return nil
+	}
+	if l.file == nil {
+		if err := os.MkdirAll(filepath.Dir(l.path), l.perm.dir); err != nil {
+			return fmt.Errorf("error creating directory %s: %v", filepath.Dir(l.path), err)
+		}
+		file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, l.perm.file)
+		if err != nil {
+			return fmt.Errorf("error creating file %s: %v", l.path, err)
+		}
+		l.file = file
+		l.writer = bufio.NewWriter(file)
+	}
+
+	entry, err := json.Marshal(badLine{Line: lineNumber, Error: parseErr.Error(), Raw: string(line)})
+	if err != nil {
+		return fmt.Errorf("error encoding bad line: %v", err)
+	}
+	if _, err := l.writer.Write(append(entry, '\n')); err != nil {
+		return fmt.Errorf("error writing to file %s: %v", l.path, err)
+	}
+	l.count++
+	return nil
+}
+
+func (l *badLineLog) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	if err := l.writer.Flush(); err != nil {
+		l.file.Close()
+		return fmt.Errorf("error writing to file %s: %v", l.path, err)
+	}
+	return l.file.Close()
+}
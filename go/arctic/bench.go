@@ -0,0 +1,126 @@
+package arctic
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// benchSubreddits is the number of distinct subreddits in the synthetic posts
+// of Benchmark.
+const benchSubreddits = 500
+
+// BenchmarkResult is the throughput of one stage of the hot path.
+type BenchmarkResult struct {
+	Stage   string        `json:"stage"`
+	Rows    int64         `json:"rows"`
+	Bytes   int64         `json:"bytes"`
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+func (r BenchmarkResult) RowsPerSecond() float64 {
+	return float64(r.Rows) / r.Elapsed.Seconds()
+}
+
+func (r BenchmarkResult) MBPerSecond() float64 {
+	return float64(r.Bytes) / (1024 * 1024) / r.Elapsed.Seconds()
+}
+
+// Benchmark measures the per-line processing and the writing of output files
+// on rows synthetic submissions, so changes to the hot path can be compared.
+// It runs two stages on a single goroutine:
+//
+//   - ProcessLines decodes every line and buffers it by subreddit and month
+//     the way ProcessFile does, honoring the options that affect decoding and
+//     routing (Fields, Transformers, Strict, NameMode and so on).
+//   - WriteJSONLChunk writes the buffered posts to uncompressed output files
+//     in a temporary directory below opts.OutputDir, or the system's if that
+//     is empty, which is removed afterwards.
+//
+// The synthetic posts are the same for the same rows.
+func Benchmark(ctx context.Context, opts Options, rows int) ([]BenchmarkResult, error) {
+	if rows < 1 {
+		return nil, fmt.Errorf("invalid benchmark rows %d: must be at least 1", rows)
+	}
+	if opts.OutputDir != "" {
+		if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+			return nil, fmt.Errorf("error creating directory %s: %v", opts.OutputDir, err)
+		}
+	}
+	dir, err := os.MkdirTemp(opts.OutputDir, "arctic-bench-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating benchmark directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The input isn't read, but has to exist
+	opts.InputDir = dir
+	opts.OutputDir = dir
+	opts.DryRun = false
+	opts.Dedupe = false
+	p, err := NewProcessor(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, size := syntheticPosts(rows)
+	fs := &FileStats{}
+	chunk := make(map[chunkKey][]Record)
+	processed := BenchmarkResult{Stage: "ProcessLines", Rows: int64(rows), Bytes: size}
+	start := time.Now()
+	for i, line := range lines {
+		if i%1000 == 0 && ctx.Err() != nil {
+			return nil, ErrInterrupted
+		}
+		sl := p.decodeLine(submissionDump, "2023-01", line, scannedLine{size: len(line) + 1, number: int64(i + 1)})
+		p.bufferLine(sl, "2023-01", nil, fs, chunk, nil)
+	}
+	processed.Elapsed = time.Since(start)
+	if fs.ParseErrors > 0 {
+		return nil, fmt.Errorf("%d synthetic posts failed to parse", fs.ParseErrors)
+	}
+
+	written := BenchmarkResult{Stage: "WriteJSONLChunk", Rows: fs.RowsWritten}
+	writers := newWriterCache(p.opts.MaxOpenFiles, p.retry, p.perm())
+	start = time.Now()
+	for key, posts := range chunk {
+		if ctx.Err() != nil {
+			writers.Close()
+			return nil, ErrInterrupted
+		}
+		if err := p.writeJSONLChunk(writers, key.partition, key.subreddit, posts); err != nil {
+			writers.Close()
+			return nil, fmt.Errorf("error writing JSONL chunk for %s: %v", key.subreddit, err)
+		}
+	}
+	if err := writers.Close(); err != nil {
+		return nil, fmt.Errorf("error closing output files: %v", err)
+	}
+	written.Elapsed = time.Since(start)
+	for _, ss := range p.stats.Subreddits {
+		written.Bytes += ss.BytesOut
+	}
+	return []BenchmarkResult{processed, written}, nil
+}
+
+// syntheticPosts returns rows submissions shaped like the ones in the dumps,
+// spread over benchSubreddits subreddits and January 2023, and their total
+// size including newlines.
+func syntheticPosts(rows int) ([][]byte, int64) {
+	rng := rand.New(rand.NewSource(1))
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	lines := make([][]byte, rows)
+	var size int64
+	for i := range lines {
+		text := make([]byte, rng.Intn(400))
+		for j := range text {
+			text[j] = 'a' + byte(rng.Intn(26))
+		}
+		lines[i] = fmt.Appendf(nil, `{"id":"%x","subreddit":"Subreddit%d","author":"user%d","created_utc":%d,"title":"Post %d","selftext":"%s","score":%d,"num_comments":%d,"over_18":false}`,
+			i, rng.Intn(benchSubreddits), rng.Intn(100000), start+rng.Int63n(31*24*3600), i, text, rng.Intn(1000), rng.Intn(100))
+		size += int64(len(lines[i])) + 1
+	}
+	return lines, size
+}
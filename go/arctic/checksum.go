@@ -0,0 +1,104 @@
+package arctic
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// newChecksum returns the hash the input dumps and the output files are
+// checksummed with, SHA-256.
+func newChecksum() hash.Hash {
+	return sha256.New()
+}
+
+func checksumString(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// VerifyChecksums recomputes the SHA-256 of every compressed output file the
+// index lists with one and compares it to the recorded one, using
+// Concurrency workers. Files without a recorded checksum, e.g. from runs
+// before checksums were recorded, are skipped and not counted in Files.
+func VerifyChecksums(ctx context.Context, opts Options) (*VerifyReport, error) {
+	if opts.Concurrency < 1 {
+		return nil, fmt.Errorf("invalid concurrency %d: must be at least 1", opts.Concurrency)
+	}
+	index, err := loadOutputIndex(opts.OutputDir, defaultPermissions)
+	if err != nil {
+		return nil, fmt.Errorf("error loading index: %v", err)
+	}
+	var files []string
+	for file, entry := range index.byFile {
+		if entry.SHA256 != "" {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+
+	report := &VerifyReport{Files: len(files)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, opts.Concurrency)
+	for _, file := range files {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(file, expected string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			path := filepath.Join(opts.OutputDir, filepath.FromSlash(file))
+			problem := ""
+			if sum, err := fileChecksum(ctx, path); err != nil {
+				problem = err.Error()
+			} else if sum != expected {
+				problem = fmt.Sprintf("checksum %s doesn't match the recorded %s", sum, expected)
+			}
+			if problem == "" || ctx.Err() != nil {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Failures++
+			report.Violations = append(report.Violations, Violation{File: path, Problem: problem})
+		}(file, index.byFile[file].SHA256)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ErrInterrupted
+	}
+
+	sort.Slice(report.Violations, func(i, j int) bool {
+		return report.Violations[i].File < report.Violations[j].File
+	})
+	return report, nil
+}
+
+// fileChecksum returns the SHA-256 of the file at path in hex.
+func fileChecksum(ctx context.Context, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("error opening file: %v", err)
+	}
+	defer file.Close()
+
+	h := newChecksum()
+	if _, err := io.Copy(h, contextReader{ctx: ctx, r: file}); err != nil {
+		return "", fmt.Errorf("error reading file: %v", err)
+	}
+	return checksumString(h), nil
+}
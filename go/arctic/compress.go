@@ -0,0 +1,353 @@
+package arctic
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression selects what the output files are compressed with at the end
+// of a run.
+type Compression string
+
+const (
+	CompressionZstd Compression = "zstd"
+	CompressionGzip Compression = "gzip"
+	CompressionNone Compression = "none" // keep the .jsonl/.csv files as they are
+)
+
+// ExistingPolicy selects what compression does with an uncompressed output
+// file whose compressed version already exists, e.g. from an earlier run.
+type ExistingPolicy string
+
+const (
+	OnExistingOverwrite ExistingPolicy = "overwrite" // replace the compressed file
+	OnExistingSkip      ExistingPolicy = "skip"      // leave both files alone
+	OnExistingMerge     ExistingPolicy = "merge"     // append to the compressed file
+)
+
+// Compression functions
+
+// gzipLevel returns the compress/gzip level of Options.GzipLevel.
+func gzipLevel(level int) int {
+	if level == 0 {
+		return gzip.DefaultCompression
+	}
+	return level
+}
+
+// compressedName returns the final name of an uncompressed output file of
+// the given format.
+func (c Compression) compressedName(path string, format OutputFormat) string {
+	switch c {
+	case CompressionNone:
+		return path
+	case CompressionGzip:
+		return path + ".gz"
+	}
+	return format.compressedName(path)
+}
+
+// CompressOutputFiles compresses every output file (.jsonl or .csv, depending
+// on the format) below the output directory using a pool of
+// CompressConcurrency workers. Failures don't stop the other files; they are
+// collected and returned together. Cancelling ctx aborts the files being
+// compressed and returns ErrInterrupted; files that weren't compressed keep
+// their uncompressed version. With CompressionNone it does nothing.
+func (p *Processor) CompressOutputFiles(ctx context.Context) error {
+	if p.opts.OutputCompression == CompressionNone {
+		return nil
+	}
+	if err := p.prepareDictionary(); err != nil {
+		return err
+	}
+
+	var paths []string
+	err := filepath.Walk(p.opts.OutputDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, p.opts.Format.extension()) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error walking output directory %s: %v", p.opts.OutputDir, err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	semaphore := make(chan struct{}, p.opts.CompressConcurrency)
+
+	for _, path := range paths {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			if err := p.compressFile(ctx, path); err != nil && ctx.Err() == nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("error compressing file %s: %v", path, err))
+				mu.Unlock()
+			}
+		}(path)
+	}
+
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
+	return errors.Join(errs...)
+}
+
+// compressFile compresses inputFile into a temporary file that is synced,
+// verified and only then handed to the sink. The original is removed last,
+// so a crash at any point leaves at least one complete copy behind.
+func (p *Processor) compressFile(ctx context.Context, inputFile string) error {
+	outputFile := p.opts.OutputCompression.compressedName(inputFile, p.opts.Format)
+	tmpFile := outputFile + ".tmp"
+
+	input, err := os.Open(inputFile)
+	if err != nil {
+		return fmt.Errorf("error opening input file %s: %v", inputFile, err)
+	}
+	defer input.Close()
+
+	var source io.Reader = input
+	merged := false
+	mtime, setMtime := p.compressedMtime(inputFile)
+	if existingInfo, err := os.Stat(outputFile); err == nil {
+		switch p.opts.OnExisting {
+		case OnExistingSkip:
+			p.log.Warn("skipping compression, the compressed file already exists", "path", inputFile, "existing", outputFile)
+			return nil
+		case OnExistingMerge:
+			existing, err := openCompressed(outputFile, p.opts.OutputCompression, p.dict)
+			if err != nil {
+				return fmt.Errorf("error opening existing file %s: %v", outputFile, err)
+			}
+			defer existing.Close()
+			if setMtime && p.opts.PreserveMtime != MtimeNow && existingInfo.ModTime().After(mtime) {
+				mtime = existingInfo.ModTime()
+			}
+			if source, err = p.appendTo(existing, input); err != nil {
+				return fmt.Errorf("error reading input file %s: %v", inputFile, err)
+			}
+			merged = true
+		}
+	}
+	// The index takes its counts from what is published, which a rerun
+	// overwriting the file doesn't add to
+	counter := &recordCounter{r: source, csv: p.opts.Format == FormatCSV}
+	source = counter
+
+	output, err := os.OpenFile(tmpFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, p.opts.FileMode)
+	if err != nil {
+		return fmt.Errorf("error creating output file %s: %v", tmpFile, err)
+	}
+	defer os.Remove(tmpFile) // no-op once published
+	defer output.Close()
+
+	// The checksum is computed while the file is written
+	checksum := newChecksum()
+	sink := io.MultiWriter(output, checksum)
+
+	var size, records int64
+	var frames []seekFrame
+	if p.opts.SeekIndex {
+		size, records, frames, err = compressFramed(ctx, sink, source, p.encoderOptions())
+		if err != nil {
+			return fmt.Errorf("error compressing file %s: %v", inputFile, err)
+		}
+	} else {
+		var encoder io.WriteCloser
+		if p.opts.OutputCompression == CompressionGzip {
+			encoder, err = gzip.NewWriterLevel(sink, gzipLevel(p.opts.GzipLevel))
+		} else {
+			encoder, err = zstd.NewWriter(sink, p.encoderOptions()...)
+		}
+		if err != nil {
+			return fmt.Errorf("error creating %s encoder: %v", p.opts.OutputCompression, err)
+		}
+		defer encoder.Close()
+
+		size, err = io.Copy(encoder, contextReader{ctx: ctx, r: source})
+		if err != nil {
+			return fmt.Errorf("error compressing file %s: %v", inputFile, err)
+		}
+		if err := encoder.Close(); err != nil {
+			return fmt.Errorf("error finishing %s stream %s: %v", p.opts.OutputCompression, tmpFile, err)
+		}
+	}
+	if err := output.Sync(); err != nil {
+		return fmt.Errorf("error syncing output file %s: %v", tmpFile, err)
+	}
+	if err := output.Close(); err != nil {
+		return fmt.Errorf("error closing output file %s: %v", tmpFile, err)
+	}
+
+	if err := verifyCompressed(tmpFile, p.opts.OutputCompression, p.dict, size); err != nil {
+		return fmt.Errorf("error verifying output file %s: %v", tmpFile, err)
+	}
+	if setMtime {
+		// Renaming and copying into place keeps it
+		if err := os.Chtimes(tmpFile, time.Time{}, mtime); err != nil {
+			return fmt.Errorf("error setting the modification time of %s: %v", tmpFile, err)
+		}
+	}
+	info, err := os.Stat(tmpFile)
+	if err != nil {
+		return fmt.Errorf("error getting file info for %s: %v", tmpFile, err)
+	}
+	rel := p.relCompressedPath(inputFile)
+	if p.opts.SeekIndex {
+		// The index records the size of the file it belongs to, so an index
+		// left next to an older version of the file is detected
+		indexFile := seekIndexName(outputFile)
+		if err := writeSeekIndex(indexFile+".tmp", p.opts.FileMode, info.Size(), records, frames); err != nil {
+			return fmt.Errorf("error writing seek index %s: %v", indexFile, err)
+		}
+		defer os.Remove(indexFile + ".tmp") // no-op once published
+		if err := p.publish(indexFile+".tmp", seekIndexName(rel)); err != nil {
+			return fmt.Errorf("error publishing seek index %s: %v", indexFile, err)
+		}
+	}
+	if err := p.publish(tmpFile, rel); err != nil {
+		return fmt.Errorf("error publishing %s: %v", outputFile, err)
+	}
+	p.index.setCompressed(rel, counter.posts(), size, info.Size(), checksumString(checksum), merged)
+
+	if p.opts.KeepJSONL {
+		return nil
+	}
+	if err := os.Remove(inputFile); err != nil {
+		return fmt.Errorf("error removing original file %s: %v", inputFile, err)
+	}
+
+	return nil
+}
+
+// recordCounter counts the records read through it: lines, or with csv rows,
+// whose quoted values may hold newlines.
+type recordCounter struct {
+	r       io.Reader
+	csv     bool
+	quoted  bool
+	records int64
+}
+
+func (rc *recordCounter) Read(p []byte) (int, error) {
+	n, err := rc.r.Read(p)
+	if !rc.csv {
+		rc.records += int64(bytes.Count(p[:n], []byte{'\n'}))
+		return n, err
+	}
+	for _, c := range p[:n] {
+		switch {
+		case c == '"':
+			rc.quoted = !rc.quoted
+		case c == '\n' && !rc.quoted:
+			rc.records++
+		}
+	}
+	return n, err
+}
+
+// posts returns the records read, without the header of csv.
+func (rc *recordCounter) posts() int64 {
+	if rc.csv {
+		return max(rc.records-1, 0)
+	}
+	return rc.records
+}
+
+// appendTo returns a reader of the decompressed existing file followed by
+// input, without the CSV header of input, which the existing file already
+// starts with.
+func (p *Processor) appendTo(existing, input io.Reader) (io.Reader, error) {
+	if p.opts.Format != FormatCSV {
+		return io.MultiReader(existing, input), nil
+	}
+	rows := bufio.NewReader(input)
+	if _, err := rows.ReadString('\n'); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return io.MultiReader(existing, rows), nil
+}
+
+// verifyCompressed decodes the file at path and checks that it holds a valid
+// stream of exactly size bytes.
+func verifyCompressed(path string, compression Compression, dict []byte, size int64) error {
+	decoder, err := openCompressed(path, compression, dict)
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+
+	n, err := io.Copy(io.Discard, decoder)
+	if err != nil {
+		return fmt.Errorf("invalid %s stream: %v", compression, err)
+	}
+	if n != size {
+		return fmt.Errorf("decompressed size %d doesn't match the original size %d", n, size)
+	}
+	return nil
+}
+
+// openCompressed opens an output file compressed with compression for
+// reading its decompressed contents.
+func openCompressed(path string, compression Compression, dict []byte) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoder io.ReadCloser
+	switch compression {
+	case CompressionNone:
+		return file, nil
+	case CompressionGzip:
+		decoder, err = gzip.NewReader(file)
+	default:
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(file, decoderOptions(dict)...); err == nil {
+			decoder = zr.IOReadCloser()
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error creating %s reader: %v", compression, err)
+	}
+	return readCloser{Reader: decoder, close: func() error {
+		decoder.Close()
+		return file.Close()
+	}}, nil
+}
+
+// readCloser closes a decoder together with the file below it.
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (rc readCloser) Close() error { return rc.close() }
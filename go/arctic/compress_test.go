@@ -0,0 +1,174 @@
+package arctic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// readCompressed returns the lines of the compressed output file at path.
+func readCompressed(tb testing.TB, p *Processor, path string) []string {
+	tb.Helper()
+	rc, err := openCompressed(path, p.opts.OutputCompression, p.dict)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// writeCompressed writes lines to path, compressed with compression.
+func writeCompressed(tb testing.TB, path string, compression Compression, lines ...string) {
+	tb.Helper()
+	if compression == CompressionZstd {
+		writeDump(tb, filepath.Dir(path), filepath.Base(path), lines...)
+		return
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	for _, line := range lines {
+		io.WriteString(gz, line+"\n")
+	}
+	if err := gz.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestCompressOutputFilesOnExisting(t *testing.T) {
+	old, fresh := post("golang", "old", 1672531200), post("golang", "new", 1672531201)
+	tests := []struct {
+		policy     ExistingPolicy
+		compressed []string
+		keepsJSONL bool
+	}{
+		{OnExistingOverwrite, []string{fresh}, false},
+		{OnExistingSkip, []string{old}, true},
+		{OnExistingMerge, []string{old, fresh}, false},
+	}
+	for _, compression := range []Compression{CompressionZstd, CompressionGzip} {
+		for _, tt := range tests {
+			t.Run(string(compression)+"/"+string(tt.policy), func(t *testing.T) {
+				opts := DefaultOptions()
+				opts.OutputCompression = compression
+				opts.OnExisting = tt.policy
+				p := newTestProcessor(t, opts)
+
+				// The compressed file of an earlier run next to new output
+				jsonl := filepath.Join(p.opts.OutputDir, "2023-01", "golang.jsonl")
+				compressed := p.opts.OutputCompression.compressedName(jsonl, p.opts.Format)
+				writeCompressed(t, compressed, compression, old)
+				if err := os.WriteFile(jsonl, []byte(fresh+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := p.CompressOutputFiles(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				if got := readCompressed(t, p, compressed); !slices.Equal(got, tt.compressed) {
+					t.Errorf("got compressed posts %q, want %q", got, tt.compressed)
+				}
+				_, err := os.Stat(jsonl)
+				if kept := err == nil; kept != tt.keepsJSONL {
+					t.Errorf("the uncompressed file was kept: %v, want %v", kept, tt.keepsJSONL)
+				}
+			})
+		}
+	}
+}
+
+func TestGzipOutputRoundTrip(t *testing.T) {
+	opts := DefaultOptions()
+	opts.OutputCompression = CompressionGzip
+	p := newTestProcessor(t, opts)
+	january := []string{post("golang", "a", 1672531200), post("golang", "b", 1672531201), post("rust", "c", 1672531202)}
+	february := []string{post("golang", "d", 1675209600)}
+	writeDump(t, p.opts.InputDir, "RS_2023-01.zst", january...)
+	writeDump(t, p.opts.InputDir, "RS_2023-02.zst", february...)
+	if _, err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read with compress/gzip rather than the package's own reader
+	gz := filepath.Join(p.opts.OutputDir, "2023-01", "golang.jsonl.gz")
+	file, err := os.Open(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := january[0] + "\n" + january[1] + "\n"; string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+	if _, err := os.Stat(strings.TrimSuffix(gz, ".gz")); !os.IsNotExist(err) {
+		t.Errorf("the uncompressed file was kept: %v", err)
+	}
+
+	report, err := VerifyOutput(context.Background(), p.opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Files != 3 || report.Records != 4 || report.Failures != 0 {
+		t.Errorf("verified %d files with %d records and %d failures, want 3, 4 and none: %v",
+			report.Files, report.Records, report.Failures, report.Violations)
+	}
+
+	merged := filepath.Join(t.TempDir(), "golang.jsonl.gz")
+	if _, err := MergeSubreddits(context.Background(), p.opts, "golang", merged); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{january[0], january[1], february[0]}
+	if got := readCompressed(t, p, merged); !slices.Equal(got, want) {
+		t.Errorf("merged %q, want %q", got, want)
+	}
+}
+
+func TestCompressOutputFilesMergesCSVWithLongHeader(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Format = FormatCSV
+	opts.OnExisting = OnExistingMerge
+	p := newTestProcessor(t, opts)
+
+	// Longer than the default bufio buffer
+	columns := make([]string, 1000)
+	for i := range columns {
+		columns[i] = fmt.Sprintf("column_%d", i)
+	}
+	header := strings.Join(columns, ",")
+	csv := filepath.Join(p.opts.OutputDir, "2023-01", "golang.csv")
+	compressed := p.opts.OutputCompression.compressedName(csv, p.opts.Format)
+	writeCompressed(t, compressed, p.opts.OutputCompression, header, "old")
+	if err := os.WriteFile(csv, []byte(header+"\nnew\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.CompressOutputFiles(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readCompressed(t, p, compressed), []string{header, "old", "new"}; !slices.Equal(got, want) {
+		t.Errorf("got %d lines starting with %.40q, want the header, old and new", len(got), got)
+	}
+}
@@ -0,0 +1,59 @@
+package arctic
+
+import (
+	"sync"
+	"time"
+)
+
+// cpuClock attributes the CPU time of the process to the files being
+// processed: whenever a file starts or finishes, the CPU time used since the
+// previous start or finish is split evenly between the files that were
+// running. Files running at the same time are charged alike, whichever of
+// them was actually busy, so it's an approximation unless Concurrency is 1.
+type cpuClock struct {
+	mu     sync.Mutex
+	last   time.Duration
+	active map[*FileStats]struct{}
+}
+
+// newCPUClock returns nil where the CPU time of the process isn't known.
+func newCPUClock() *cpuClock {
+	now, ok := processCPUTime()
+	if !ok {
+		return nil
+	}
+	return &cpuClock{last: now, active: make(map[*FileStats]struct{})}
+}
+
+// start begins charging fs.
+func (c *cpuClock) start(fs *FileStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advance()
+	c.active[fs] = struct{}{}
+}
+
+// stop charges fs its share of the CPU time since the last change and stops
+// charging it.
+func (c *cpuClock) stop(fs *FileStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advance()
+	delete(c.active, fs)
+}
+
+// advance splits the CPU time used since the last call between the active
+// files. c.mu must be held.
+func (c *cpuClock) advance() {
+	now, ok := processCPUTime()
+	if !ok {
+		return
+	}
+	if len(c.active) > 0 {
+		share := (now - c.last) / time.Duration(len(c.active))
+		for fs := range c.active {
+			fs.CPUTime += share
+		}
+	}
+	c.last = now
+}
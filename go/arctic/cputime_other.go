@@ -0,0 +1,10 @@
+//go:build !unix
+
+package arctic
+
+import "time"
+
+// processCPUTime reports false, the CPU time isn't measured here.
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,88 @@
+package arctic
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// OutputFormat selects how records are written to the per-subreddit files.
+type OutputFormat string
+
+const (
+	FormatJSONL OutputFormat = "jsonl"
+	FormatCSV   OutputFormat = "csv"
+)
+
+// DefaultColumns are the CSV columns used when Options.Columns is empty.
+var DefaultColumns = []string{"subreddit", "created_utc", "id", "author", "score"}
+
+func (f OutputFormat) extension() string {
+	return "." + string(f)
+}
+
+// compressedName returns the name of the compressed version of path. JSONL
+// files keep their historical "<subreddit>.zst" name, other formats keep
+// their extension, e.g. "<subreddit>.csv.zst".
+func (f OutputFormat) compressedName(path string) string {
+	if f == FormatJSONL {
+		return strings.TrimSuffix(path, f.extension()) + ".zst"
+	}
+	return path + ".zst"
+}
+
+// encodeCSVRows encodes data as CSV rows with the configured columns and
+// returns the header row and every record's row, so their sizes are known
+// before anything is written. Strings are written unquoted, missing fields
+// and nulls as empty cells and any other value as its JSON text.
+func (p *Processor) encodeCSVRows(data []Record) ([]byte, [][]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(p.opts.Columns); err != nil {
+		return nil, nil, fmt.Errorf("error encoding CSV header: %v", err)
+	}
+	w.Flush()
+	ends := make([]int, 0, len(data)+1)
+	ends = append(ends, buf.Len())
+
+	row := make([]string, len(p.opts.Columns))
+	for _, item := range data {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(item.rawJSON(), &fields); err != nil {
+			return nil, nil, fmt.Errorf("error decoding record for CSV: %v", err)
+		}
+		for i, column := range p.opts.Columns {
+			row[i] = csvValue(fields[column])
+		}
+		if err := w.Write(row); err != nil {
+			return nil, nil, err
+		}
+		w.Flush()
+		ends = append(ends, buf.Len())
+	}
+	if err := w.Error(); err != nil {
+		return nil, nil, err
+	}
+
+	encoded := buf.Bytes()
+	rows := make([][]byte, len(data))
+	for i := range rows {
+		rows[i] = encoded[ends[i]:ends[i+1]]
+	}
+	return encoded[:ends[0]], rows, nil
+}
+
+func csvValue(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			return s
+		}
+	}
+	return string(raw)
+}
@@ -0,0 +1,104 @@
+package arctic
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecodeCreatedUTC(t *testing.T) {
+	lines := map[string]float64{
+		`{"id":"a","subreddit":"s","created_utc":1672531200}`:     1672531200,
+		`{"id":"a","subreddit":"s","created_utc":"1672531200"}`:   1672531200,
+		`{"id":"a","subreddit":"s","created_utc":1672531200.5}`:   1672531200.5,
+		`{"id":"a","subreddit":"s","created_utc":"1672531200.5"}`: 1672531200.5,
+		`{"id":"a","subreddit":"s","created_utc":null}`:           0,
+		`{"id":"a","subreddit":"s"}`:                              0,
+	}
+	decoders := map[string]func(dumpKind, []byte) (Record, error){
+		"decodeRecord":     decodeRecord,
+		"decodeRecordFast": decodeRecordFast,
+	}
+	for name, decode := range decoders {
+		for _, kind := range []dumpKind{submissionDump, commentDump} {
+			for line, want := range lines {
+				record, err := decode(kind, []byte(line))
+				if err != nil {
+					t.Errorf("%s(%d, %s): %v", name, kind, line, err)
+					continue
+				}
+				if got := record.createdUTC(); got != want {
+					t.Errorf("%s(%d, %s) created at %v, want %v", name, kind, line, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeInvalidCreatedUTC(t *testing.T) {
+	for _, line := range []string{
+		`{"id":"a","subreddit":"s","created_utc":"yesterday"}`,
+		`{"id":"a","subreddit":"s","created_utc":true}`,
+		`{"id":"a","subreddit":"s","created_utc":"NaN"}`,
+		`{"id":"a","subreddit":"s","created_utc":"Inf"}`,
+		`{"id":"a","subreddit":"s","created_utc":"-infinity"}`,
+	} {
+		if _, err := decodeRecord(submissionDump, []byte(line)); err == nil {
+			t.Errorf("decodeRecord accepted %s", line)
+		}
+		if _, err := decodeRecordFast(submissionDump, []byte(line)); err == nil {
+			t.Errorf("decodeRecordFast accepted %s", line)
+		}
+	}
+}
+
+func TestProcessFileBOMAndBlankLines(t *testing.T) {
+	opts := DefaultOptions()
+	opts.OutputCompression = CompressionNone
+	p := newTestProcessor(t, opts)
+	first, second := post("golang", "a", 1672531200), post("golang", "b", 1672531201)
+	path := writeDump(t, p.opts.InputDir, "RS_2023-01.zst",
+		"\xEF\xBB\xBF"+first,
+		"",
+		"   \t",
+		"\r",
+		second,
+		"",
+	)
+	if err := p.ProcessFile(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readLines(t, filepath.Join(p.opts.OutputDir, "2023-01", "golang.jsonl"))
+	if len(lines) != 2 || lines[0] != first || lines[1] != second {
+		t.Errorf("got %q, want both posts without the BOM", lines)
+	}
+	fs := p.Stats().Files[filepath.Base(path)]
+	if fs.ParseErrors != 0 || fs.RowsRead != 2 {
+		t.Errorf("got %d rows read and %d parse errors, want 2 rows and no errors", fs.RowsRead, fs.ParseErrors)
+	}
+}
+
+// BenchmarkDecodeRecord compares encoding/json with the field scanner used
+// with FastDecode.
+func BenchmarkDecodeRecord(b *testing.B) {
+	lines, size := syntheticPosts(10000)
+	decoders := []struct {
+		name   string
+		decode func(dumpKind, []byte) (Record, error)
+	}{
+		{"json", decodeRecord},
+		{"fast", decodeRecordFast},
+	}
+	for _, d := range decoders {
+		b.Run(d.name, func(b *testing.B) {
+			b.SetBytes(size / int64(len(lines)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := d.decode(submissionDump, lines[i%len(lines)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package arctic
+
+// idWindow remembers the most recently seen post ids to detect duplicates.
+// Memory stays bounded: once size ids are stored, the oldest one is
+// forgotten for every new one. Duplicates in dumps are usually close to each
+// other since the dumps are sorted by created_utc, so a window catches them
+// without false positives.
+type idWindow struct {
+	seen map[string]struct{}
+	ring []string
+	size int
+	next int
+}
+
+// newIDWindow returns an empty window that grows as ids are added, up to
+// size, so short dumps don't pay for the full window.
+func newIDWindow(size int) *idWindow {
+	return &idWindow{seen: make(map[string]struct{}), size: size}
+}
+
+// seenBefore reports whether id is in the window and adds it if it isn't.
+// Empty ids are never considered duplicates.
+func (w *idWindow) seenBefore(id string) bool {
+	if id == "" {
+		return false
+	}
+	if _, ok := w.seen[id]; ok {
+		return true
+	}
+
+	if len(w.ring) < w.size {
+		w.ring = append(w.ring, id)
+	} else {
+		delete(w.seen, w.ring[w.next])
+		w.ring[w.next] = id
+		w.next = (w.next + 1) % len(w.ring)
+	}
+	w.seen[id] = struct{}{}
+	return false
+}
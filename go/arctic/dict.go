@@ -0,0 +1,166 @@
+package arctic
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// dictionaryName is the zstd dictionary the output files of a directory are
+// compressed with, if any. Decompressing them needs it, e.g. with
+// "zstd -d -D _zstd_dictionary".
+const dictionaryName = "_zstd_dictionary"
+
+const (
+	// maxDictBytes is the size of the history of trained dictionaries, the
+	// default of the zstd CLI.
+	maxDictBytes = 112 * 1024
+
+	// dictSampleBytes caps the lines sampled for training, dictLinesPerFile
+	// the lines taken from each file, so the sample spans many subreddits.
+	dictSampleBytes  = 8 * 1024 * 1024
+	dictLinesPerFile = 16
+)
+
+// LoadDictionary returns the zstd dictionary of an output directory, nil if
+// its files are compressed without one.
+func LoadDictionary(outputDir string) ([]byte, error) {
+	dict, err := os.ReadFile(filepath.Join(outputDir, dictionaryName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading dictionary: %v", err)
+	}
+	return dict, nil
+}
+
+// TrainDictionary builds a zstd dictionary from samples of the data it will
+// compress, e.g. single records. Dictionaries mostly help small files, where
+// there is too little data for the compressor to learn the common field
+// names and values from.
+func TrainDictionary(samples [][]byte, level zstd.EncoderLevel) ([]byte, error) {
+	var history []byte
+	for _, sample := range samples {
+		if len(history)+len(sample) > maxDictBytes {
+			break
+		}
+		history = append(history, sample...)
+	}
+	// IDs below 32768 are reserved
+	h := fnv.New32a()
+	h.Write(history)
+	id := 32768 + h.Sum32()%(1<<31-32768)
+	dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       id,
+		Contents: samples,
+		History:  history,
+		Offsets:  [3]int{1, 4, 8},
+		Level:    level,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error building dictionary: %v", err)
+	}
+	return dict, nil
+}
+
+// prepareDictionary picks the dictionary to compress the output with: the
+// one already used in the output directory, Options.Dictionary or one
+// trained on the uncompressed output. A new dictionary is saved to the
+// output directory before anything is compressed with it.
+func (p *Processor) prepareDictionary() error {
+	if p.dict != nil || p.opts.OutputCompression != CompressionZstd {
+		return nil
+	}
+	dict := p.opts.Dictionary
+	if dict == nil && p.opts.TrainDictionary {
+		samples, err := p.dictionarySamples()
+		if err != nil {
+			return err
+		}
+		if len(samples) == 0 {
+			return nil
+		}
+		if dict, err = TrainDictionary(samples, p.opts.CompressionLevel); err != nil {
+			return err
+		}
+		p.log.Info("trained compression dictionary", "samples", len(samples), "bytes", len(dict))
+	}
+	if dict == nil {
+		return nil
+	}
+
+	path := filepath.Join(p.opts.OutputDir, dictionaryName)
+	if err := os.WriteFile(path+".tmp", dict, p.opts.FileMode); err != nil {
+		return fmt.Errorf("error writing dictionary %s: %v", path, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("error renaming %s to %s: %v", path+".tmp", path, err)
+	}
+	p.dict = dict
+	return nil
+}
+
+// dictionarySamples takes the first lines of the uncompressed output files,
+// up to dictSampleBytes in total.
+func (p *Processor) dictionarySamples() ([][]byte, error) {
+	var paths []string
+	err := filepath.Walk(p.opts.OutputDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, p.opts.Format.extension()) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking output directory %s: %v", p.opts.OutputDir, err)
+	}
+	sort.Strings(paths)
+
+	var samples [][]byte
+	size := 0
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s: %v", path, err)
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, maxDictBytes)
+		for i := 0; i < dictLinesPerFile && size < dictSampleBytes && scanner.Scan(); i++ {
+			samples = append(samples, append(bytes.Clone(scanner.Bytes()), '\n'))
+			size += len(scanner.Bytes()) + 1
+		}
+		file.Close()
+		if size >= dictSampleBytes {
+			break
+		}
+	}
+	return samples, nil
+}
+
+// encoderOptions returns the options of the zstd encoders of the output.
+func (p *Processor) encoderOptions() []zstd.EOption {
+	opts := []zstd.EOption{zstd.WithEncoderLevel(p.opts.CompressionLevel)}
+	if p.dict != nil {
+		opts = append(opts, zstd.WithEncoderDict(p.dict))
+	}
+	return opts
+}
+
+// decoderOptions returns the options of zstd decoders for files compressed
+// with dict, which may be nil.
+func decoderOptions(dict []byte) []zstd.DOption {
+	if dict == nil {
+		return nil
+	}
+	return []zstd.DOption{zstd.WithDecoderDicts(dict)}
+}
@@ -0,0 +1,87 @@
+package arctic
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressedOutput returns the total size of the .zst files below dir.
+func compressedOutput(tb testing.TB, dir string) int64 {
+	tb.Helper()
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".zst") {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return total
+}
+
+// TestTrainDictionaryCompressesSmallFiles splits 1500 posts over 500
+// subreddits, about 3 posts or 1KB per file. A dictionary trained on them
+// makes the output about a third smaller.
+func TestTrainDictionaryCompressesSmallFiles(t *testing.T) {
+	input := t.TempDir()
+	writeSyntheticDump(t, input, "RS_2023-01.zst", 1500)
+
+	sizes := make(map[bool]int64)
+	for _, train := range []bool{false, true} {
+		opts := DefaultOptions()
+		opts.InputDir = input
+		opts.TrainDictionary = train
+		p := newTestProcessor(t, opts)
+		if _, err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		sizes[train] = compressedOutput(t, p.opts.OutputDir)
+
+		if train {
+			checkDictionaryOutput(t, p.opts.OutputDir)
+		}
+	}
+	ratio := float64(sizes[true]) / float64(sizes[false])
+	t.Logf("%d bytes without a dictionary, %d bytes with one, %.0f%%", sizes[false], sizes[true], ratio*100)
+	if ratio > 0.8 {
+		t.Errorf("the dictionary only shrank the output to %.0f%%", ratio*100)
+	}
+}
+
+// checkDictionaryOutput decompresses an output file of dir with the
+// dictionary saved there.
+func checkDictionaryOutput(tb testing.TB, dir string) {
+	tb.Helper()
+	dict, err := LoadDictionary(dir)
+	if err != nil || dict == nil {
+		tb.Fatalf("no dictionary was saved: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "2023-01", "Subreddit0.zst"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, opts := range [][]zstd.DOption{nil, decoderOptions(dict)} {
+		decoder, err := zstd.NewReader(nil, opts...)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		_, err = decoder.DecodeAll(data, nil)
+		decoder.Close()
+		if withDict := opts != nil; (err == nil) != withDict {
+			tb.Errorf("decoding with the dictionary: %v, got error %v", withDict, err)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package arctic
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+// decodeRecordFast is decodeRecord without encoding/json for the common
+// case: a single pass over the line checks that it is valid JSON and picks
+// out the fields routing needs, skipping all others. Lines it isn't sure
+// about, such as escaped keys and strings, keys differing from the field
+// names only in case or fields of the wrong type, go through decodeRecord,
+// so the result and the errors are the same either way.
+func decodeRecordFast(kind dumpKind, line []byte) (Record, error) {
+	fields, ok := scanRecordFields(line, kind)
+	if !ok {
+		return decodeRecord(kind, line)
+	}
+	if kind == mixedDump {
+		kind = detectKind(fields.hasBody, fields.hasTitle)
+	}
+
+	// The line reader reuses its buffer, so the raw line has to be copied
+	raw := append(json.RawMessage(nil), line...)
+	if kind == commentDump {
+		return RedditComment{
+			ID: fields.id, Subreddit: fields.subreddit, Author: fields.author, CreatedUTC: fields.createdUTC,
+			Body: fields.body, LinkID: fields.linkID, ParentID: fields.parentID, Raw: raw,
+		}, nil
+	}
+	return RedditPost{
+		ID: fields.id, Subreddit: fields.subreddit, Author: fields.author, Domain: fields.domain,
+		CreatedUTC: fields.createdUTC, Raw: raw,
+	}, nil
+}
+
+// recordFields are the fields of RedditPost and RedditComment. hasBody and
+// hasTitle are only set for a mixedDump.
+type recordFields struct {
+	id, subreddit, author, domain string
+	body, linkID, parentID        string
+	createdUTC                    float64
+	hasBody, hasTitle             bool
+}
+
+// scanRecordFields reads the fields of kind from a line holding a JSON
+// object, those of both kinds for a mixedDump. It returns false if the line
+// isn't valid JSON or needs decodeRecord.
+func scanRecordFields(line []byte, kind dumpKind) (recordFields, bool) {
+	var fields recordFields
+	comment, post := kind != submissionDump, kind != commentDump
+	s := fieldScanner{data: line}
+	s.skipSpace()
+	if !s.consume('{') {
+		return fields, false
+	}
+	s.skipSpace()
+	if !s.consume('}') {
+		for {
+			s.skipSpace()
+			keyStart := s.pos
+			if s.peek() != '"' {
+				return fields, false
+			}
+			escaped, ok := s.skipString()
+			if !ok || escaped {
+				return fields, false
+			}
+			key := line[keyStart+1 : s.pos-1]
+			s.skipSpace()
+			if !s.consume(':') {
+				return fields, false
+			}
+			s.skipSpace()
+			valueStart := s.pos
+			if !s.skipValue() {
+				return fields, false
+			}
+			value := line[valueStart:s.pos]
+
+			var target *string
+			switch string(key) {
+			case "id":
+				target = &fields.id
+			case "subreddit":
+				target = &fields.subreddit
+			case "author":
+				target = &fields.author
+			case "domain":
+				if post {
+					target = &fields.domain
+				}
+			case "title":
+				fields.hasTitle = kind == mixedDump
+			case "created_utc":
+				if !setFlexibleFloat(&fields.createdUTC, value) {
+					return fields, false
+				}
+			case "body":
+				fields.hasBody = kind == mixedDump
+				if comment {
+					target = &fields.body
+				}
+			case "link_id":
+				if comment {
+					target = &fields.linkID
+				}
+			case "parent_id":
+				if comment {
+					target = &fields.parentID
+				}
+			default:
+				if foldsToField(key, kind) {
+					return fields, false
+				}
+			}
+			if target != nil && !setString(target, value) {
+				return fields, false
+			}
+
+			s.skipSpace()
+			if s.consume('}') {
+				break
+			}
+			if !s.consume(',') {
+				return fields, false
+			}
+		}
+	}
+	s.skipSpace()
+	return fields, s.pos == len(line)
+}
+
+// foldsToField reports whether encoding/json might match key to one of the
+// fields of kind although it isn't spelled exactly like it. It folds some
+// non-ASCII letters to ASCII ones, so keys with those always need
+// decodeRecord.
+func foldsToField(key []byte, kind dumpKind) bool {
+	if !isASCII(key) {
+		return true
+	}
+	names := []string{"id", "subreddit", "author", "created_utc"}
+	if kind != submissionDump {
+		names = append(names, "body", "link_id", "parent_id")
+	}
+	if kind != commentDump {
+		names = append(names, "domain")
+	}
+	for _, name := range names {
+		if len(key) == len(name) && equalFoldASCII(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func isASCII(b []byte) bool {
+	for _, c := range b {
+		if c >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func equalFoldASCII(a []byte, b string) bool {
+	for i := range a {
+		c := a[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// setString stores a JSON string value like encoding/json. It returns false
+// for values that need unescaping or aren't strings.
+func setString(target *string, value []byte) bool {
+	if string(value) == "null" {
+		return true
+	}
+	if value[0] != '"' {
+		return false
+	}
+	content := value[1 : len(value)-1]
+	if bytes.IndexByte(content, '\\') >= 0 || !utf8.Valid(content) {
+		return false
+	}
+	*target = string(content)
+	return true
+}
+
+// setFlexibleFloat stores a created_utc value like flexibleFloat.
+func setFlexibleFloat(target *float64, value []byte) bool {
+	if string(value) == "null" {
+		return true
+	}
+	if value[0] == '"' {
+		value = value[1 : len(value)-1]
+	} else if value[0] != '-' && (value[0] < '0' || value[0] > '9') {
+		return false
+	}
+	v, err := strconv.ParseFloat(string(value), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return false
+	}
+	*target = v
+	return true
+}
+
+// fieldScanner walks JSON text, validating what it skips.
+type fieldScanner struct {
+	data []byte
+	pos  int
+}
+
+func (s *fieldScanner) peek() byte {
+	if s.pos < len(s.data) {
+		return s.data[s.pos]
+	}
+	return 0
+}
+
+func (s *fieldScanner) consume(c byte) bool {
+	if s.peek() == c && s.pos < len(s.data) {
+		s.pos++
+		return true
+	}
+	return false
+}
+
+func (s *fieldScanner) skipSpace() {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\n', '\r':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+// skipString skips the string starting at pos and reports whether it had
+// escapes.
+func (s *fieldScanner) skipString() (escaped, ok bool) {
+	s.pos++ // the opening quote
+	for s.pos < len(s.data) {
+		c := s.data[s.pos]
+		switch {
+		case c == '"':
+			s.pos++
+			return escaped, true
+		case c == '\\':
+			escaped = true
+			if s.pos+1 >= len(s.data) {
+				return escaped, false
+			}
+			switch s.data[s.pos+1] {
+			case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+				s.pos += 2
+			case 'u':
+				if s.pos+6 > len(s.data) {
+					return escaped, false
+				}
+				for _, h := range s.data[s.pos+2 : s.pos+6] {
+					if !isHex(h) {
+						return escaped, false
+					}
+				}
+				s.pos += 6
+			default:
+				return escaped, false
+			}
+		case c < 0x20:
+			return escaped, false
+		default:
+			s.pos++
+		}
+	}
+	return escaped, false
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// skipNumber skips a number following the JSON grammar.
+func (s *fieldScanner) skipNumber() bool {
+	s.consume('-')
+	switch {
+	case s.consume('0'):
+	case isDigit(s.peek()):
+		for isDigit(s.peek()) {
+			s.pos++
+		}
+	default:
+		return false
+	}
+	if s.consume('.') {
+		if !isDigit(s.peek()) {
+			return false
+		}
+		for isDigit(s.peek()) {
+			s.pos++
+		}
+	}
+	if s.consume('e') || s.consume('E') {
+		if !s.consume('+') {
+			s.consume('-')
+		}
+		if !isDigit(s.peek()) {
+			return false
+		}
+		for isDigit(s.peek()) {
+			s.pos++
+		}
+	}
+	return true
+}
+
+func (s *fieldScanner) skipLiteral(literal string) bool {
+	if len(s.data)-s.pos < len(literal) || string(s.data[s.pos:s.pos+len(literal)]) != literal {
+		return false
+	}
+	s.pos += len(literal)
+	return true
+}
+
+// skipKey skips an object key and the colon after it.
+func (s *fieldScanner) skipKey() bool {
+	s.skipSpace()
+	if s.peek() != '"' {
+		return false
+	}
+	if _, ok := s.skipString(); !ok {
+		return false
+	}
+	s.skipSpace()
+	return s.consume(':')
+}
+
+// skipValue skips the value starting at pos, including nested objects and
+// arrays, without recursing.
+func (s *fieldScanner) skipValue() bool {
+	var open []byte // the brackets of the enclosing objects and arrays
+	for {
+		s.skipSpace()
+		switch c := s.peek(); c {
+		case '{', '[':
+			s.pos++
+			s.skipSpace()
+			if s.consume(c + 2) { // '}' and ']'
+				break
+			}
+			open = append(open, c)
+			if c == '{' && !s.skipKey() {
+				return false
+			}
+			continue
+		case '"':
+			if _, ok := s.skipString(); !ok {
+				return false
+			}
+		case 't':
+			if !s.skipLiteral("true") {
+				return false
+			}
+		case 'f':
+			if !s.skipLiteral("false") {
+				return false
+			}
+		case 'n':
+			if !s.skipLiteral("null") {
+				return false
+			}
+		default:
+			if !s.skipNumber() {
+				return false
+			}
+		}
+
+		// Close finished objects and arrays until the next value
+		for {
+			if len(open) == 0 {
+				return true
+			}
+			s.skipSpace()
+			bracket := open[len(open)-1]
+			if s.consume(bracket + 2) {
+				open = open[:len(open)-1]
+				continue
+			}
+			if !s.consume(',') || (bracket == '{' && !s.skipKey()) {
+				return false
+			}
+			break
+		}
+	}
+}
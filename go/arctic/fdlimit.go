@@ -0,0 +1,35 @@
+package arctic
+
+import "log/slog"
+
+// Descriptors kept free of output files: a fixed share for the manifest,
+// logs, stdio and such, and some per worker for its input, spill file and
+// the compression that follows.
+const (
+	fdReserve          = 32
+	fdReservePerWorker = 4
+)
+
+// lowOpenFileLimit is the per worker budget below which the cap is worth a
+// warning, as files then get closed and reopened between most chunks.
+const lowOpenFileLimit = 16
+
+// capOpenFiles lowers maxOpen, the output files each of the workers keeps
+// open, so all of them together stay within the descriptor limit of the
+// process. Without a known limit maxOpen is returned as is.
+func capOpenFiles(maxOpen, workers int, log *slog.Logger) int {
+	limit := openFileLimit()
+	if limit <= 0 {
+		return maxOpen
+	}
+	budget := max((limit-fdReserve-fdReservePerWorker*workers)/workers, 1)
+	if budget < lowOpenFileLimit {
+		log.Warn("low open file limit, output files will be reopened often; raise it with ulimit -n or lower the concurrency",
+			"limit", limit, "concurrency", workers, "files_per_worker", budget)
+	}
+	if maxOpen <= budget {
+		return maxOpen
+	}
+	log.Info("capping max open files to the open file limit", "limit", limit, "max_open_files", budget)
+	return budget
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package arctic
+
+// openFileLimit returns 0, there's no descriptor limit to query here.
+func openFileLimit() int {
+	return 0
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package arctic
+
+import (
+	"math"
+	"syscall"
+)
+
+// openFileLimit returns the soft RLIMIT_NOFILE of the process, which the Go
+// runtime already raised to the hard limit at startup, or 0 if unknown.
+func openFileLimit() int {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		return 0
+	}
+	if rlim.Cur > math.MaxInt32 { // unlimited, or as good as
+		return 0
+	}
+	return int(rlim.Cur)
+}
@@ -0,0 +1,385 @@
+package arctic
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// filterFieldType is the type of a field a Filter can compare.
+type filterFieldType int
+
+const (
+	stringFilterField filterFieldType = iota
+	numberFilterField
+	boolFilterField
+)
+
+func (t filterFieldType) String() string {
+	return [...]string{"string", "number", "boolean"}[t]
+}
+
+// filterFields are the top-level fields of submissions and comments a Filter
+// can compare, with their types. Fields only one of them has never match
+// posts of the other.
+var filterFields = map[string]filterFieldType{
+	"id":                    stringFilterField,
+	"name":                  stringFilterField,
+	"subreddit":             stringFilterField,
+	"subreddit_id":          stringFilterField,
+	"author":                stringFilterField,
+	"author_fullname":       stringFilterField,
+	"author_flair_text":     stringFilterField,
+	"title":                 stringFilterField,
+	"selftext":              stringFilterField,
+	"body":                  stringFilterField,
+	"domain":                stringFilterField,
+	"url":                   stringFilterField,
+	"permalink":             stringFilterField,
+	"link_flair_text":       stringFilterField,
+	"link_id":               stringFilterField,
+	"parent_id":             stringFilterField,
+	"distinguished":         stringFilterField,
+	"removed_by_category":   stringFilterField,
+	"created_utc":           numberFilterField,
+	"retrieved_on":          numberFilterField,
+	"score":                 numberFilterField,
+	"ups":                   numberFilterField,
+	"downs":                 numberFilterField,
+	"upvote_ratio":          numberFilterField,
+	"num_comments":          numberFilterField,
+	"num_crossposts":        numberFilterField,
+	"gilded":                numberFilterField,
+	"total_awards_received": numberFilterField,
+	"controversiality":      numberFilterField,
+	"over_18":               boolFilterField,
+	"is_self":               boolFilterField,
+	"is_video":              boolFilterField,
+	"spoiler":               boolFilterField,
+	"stickied":              boolFilterField,
+	"locked":                boolFilterField,
+	"archived":              boolFilterField,
+	"is_submitter":          boolFilterField,
+}
+
+// Filter is a predicate over the fields of a post, see ParseFilter.
+type Filter struct {
+	expr string
+	root filterNode
+}
+
+// ParseFilter parses a filter expression. An expression compares fields with
+// values, like
+//
+//	score > 100 && author != AutoModerator
+//	(subreddit == AskReddit || subreddit == "ask science") && over_18 == false
+//
+// Strings can be compared with == and !=, numbers with ==, !=, <, <=, > and
+// >=, booleans (true or false) with == and !=. String values need quotes if
+// they contain spaces, operators or parentheses, and are compared case
+// sensitively. && binds tighter than ||. Every comparison of a field the post
+// lacks, or which is null or of another type, is false, != included.
+//
+// Only common top-level fields such as author, score, over_18 or created_utc
+// can be used; others are an error listing the supported ones, and so are
+// values of the wrong type.
+func ParseFilter(expr string) (*Filter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %v", expr, err)
+	}
+	parser := &filterParser{tokens: tokens}
+	root, err := parser.or()
+	if err == nil && parser.pos < len(tokens) {
+		err = fmt.Errorf("unexpected %q", tokens[parser.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %v", expr, err)
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Match reports whether the post passes the filter. Lines that aren't JSON
+// objects never do.
+func (f *Filter) Match(raw []byte) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return false
+	}
+	return f.root.match(fields)
+}
+
+// filterFieldNames returns the fields filters can use, sorted.
+func filterFieldNames() []string {
+	names := make([]string, 0, len(filterFields))
+	for name := range filterFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type filterNode interface {
+	match(fields map[string]json.RawMessage) bool
+}
+
+type filterOr []filterNode
+
+func (n filterOr) match(fields map[string]json.RawMessage) bool {
+	for _, child := range n {
+		if child.match(fields) {
+			return true
+		}
+	}
+	return false
+}
+
+type filterAnd []filterNode
+
+func (n filterAnd) match(fields map[string]json.RawMessage) bool {
+	for _, child := range n {
+		if !child.match(fields) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterComparison compares a field with a value of the field's type.
+type filterComparison struct {
+	field   string
+	typ     filterFieldType
+	op      string
+	str     string
+	number  float64
+	boolean bool
+}
+
+func (c filterComparison) match(fields map[string]json.RawMessage) bool {
+	value, ok := fields[c.field]
+	if !ok || string(value) == "null" {
+		return false
+	}
+	switch c.typ {
+	case stringFilterField:
+		var s string
+		if value[0] != '"' || json.Unmarshal(value, &s) != nil {
+			return false
+		}
+		return compare(strings.Compare(s, c.str), c.op)
+	case numberFilterField:
+		var f flexibleFloat
+		if json.Unmarshal(value, &f) != nil {
+			return false
+		}
+		switch {
+		case float64(f) < c.number:
+			return compare(-1, c.op)
+		case float64(f) > c.number:
+			return compare(1, c.op)
+		}
+		return compare(0, c.op)
+	default:
+		var b bool
+		if json.Unmarshal(value, &b) != nil {
+			return false
+		}
+		return (b == c.boolean) == (c.op == "==")
+	}
+}
+
+// compare applies op to the result of comparing two values, -1, 0 or 1.
+func compare(cmp int, op string) bool {
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+type filterToken struct {
+	text   string
+	quoted bool // a string in quotes, never an operator
+}
+
+// syntax reports whether the token is an operator or a parenthesis.
+func (t filterToken) syntax() bool {
+	if t.quoted {
+		return false
+	}
+	switch t.text {
+	case "(", ")", "&&", "||", "==", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+// tokenizeFilter splits an expression into parentheses, operators, quoted
+// strings and words.
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, filterToken{text: expr[i : i+1]})
+			i++
+		case strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||") ||
+			strings.HasPrefix(expr[i:], "==") || strings.HasPrefix(expr[i:], "!=") ||
+			strings.HasPrefix(expr[i:], "<=") || strings.HasPrefix(expr[i:], ">="):
+			tokens = append(tokens, filterToken{text: expr[i : i+2]})
+			i += 2
+		case c == '<' || c == '>':
+			tokens = append(tokens, filterToken{text: expr[i : i+1]})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			s, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", expr[i:end+1])
+			}
+			tokens = append(tokens, filterToken{text: s, quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(expr) && !strings.ContainsRune(" \t\n()&|=!<>\"", rune(expr[end])) {
+				end++
+			}
+			if end == i {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, filterToken{text: expr[i:end]})
+			i = end
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	return tokens, nil
+}
+
+// filterParser is a recursive descent parser over the tokens of an
+// expression.
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// is reports whether the next token is the operator or parenthesis text.
+func (p *filterParser) is(text string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && p.tokens[p.pos].text == text
+}
+
+func (p *filterParser) or() (filterNode, error) {
+	var nodes filterOr
+	for {
+		node, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if !p.is("||") {
+			break
+		}
+		p.pos++
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *filterParser) and() (filterNode, error) {
+	var nodes filterAnd
+	for {
+		node, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if !p.is("&&") {
+			break
+		}
+		p.pos++
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+// operand parses a parenthesized expression or a comparison.
+func (p *filterParser) operand() (filterNode, error) {
+	if p.is("(") {
+		p.pos++
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.is(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return node, nil
+	}
+
+	if p.pos+3 > len(p.tokens) {
+		return nil, fmt.Errorf("incomplete comparison at the end")
+	}
+	field, op, value := p.tokens[p.pos], p.tokens[p.pos+1], p.tokens[p.pos+2]
+	p.pos += 3
+	typ, ok := filterFields[field.text]
+	if field.quoted || !ok {
+		return nil, fmt.Errorf("unsupported field %q, supported are %s", field.text, strings.Join(filterFieldNames(), ", "))
+	}
+	c := filterComparison{field: field.text, typ: typ, op: op.text}
+	switch {
+	case !op.syntax() || op.text == "(" || op.text == ")" || op.text == "&&" || op.text == "||":
+		return nil, fmt.Errorf("expected an operator after %s, got %q", field.text, op.text)
+	case typ != numberFilterField && op.text != "==" && op.text != "!=":
+		return nil, fmt.Errorf("%s is a %s and only supports == and !=", field.text, typ)
+	case value.syntax():
+		return nil, fmt.Errorf("expected a value after %s %s, got %q", field.text, op.text, value.text)
+	}
+
+	var err error
+	switch typ {
+	case stringFilterField:
+		c.str = value.text
+	case numberFilterField:
+		if c.number, err = strconv.ParseFloat(value.text, 64); err != nil {
+			return nil, fmt.Errorf("%s is a number, got %q", field.text, value.text)
+		}
+	case boolFilterField:
+		if value.quoted || (value.text != "true" && value.text != "false") {
+			return nil, fmt.Errorf("%s is a boolean, got %q", field.text, value.text)
+		}
+		c.boolean = value.text == "true"
+	}
+	return c, nil
+}
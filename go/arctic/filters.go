@@ -0,0 +1,79 @@
+package arctic
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultDeletedMarkers are what reddit puts in place of the author and text
+// of deleted and removed posts.
+var DefaultDeletedMarkers = []string{"[deleted]", "[removed]"}
+
+// inDateRange reports whether a post created at createdUTC passes the date
+// filters. After is inclusive, Before is exclusive. Posts without a timestamp
+// are kept unless DropUndated is set.
+func (p *Processor) inDateRange(createdUTC float64) bool {
+	if createdUTC == 0 {
+		return !p.opts.DropUndated
+	}
+	created := time.Unix(0, int64(createdUTC*float64(time.Second)))
+	if !p.opts.After.IsZero() && created.Before(p.opts.After) {
+		return false
+	}
+	if !p.opts.Before.IsZero() && !created.Before(p.opts.Before) {
+		return false
+	}
+	return true
+}
+
+// isDeleted reports whether the author, or the body or selftext, of record is
+// one of the DeletedMarkers. Posts don't decode their selftext, so it's only
+// read from line when the line contains a marker at all.
+func (p *Processor) isDeleted(record Record, line []byte) bool {
+	markers := p.opts.DeletedMarkers
+	if slices.Contains(markers, record.authorName()) {
+		return true
+	}
+	if comment, ok := record.(RedditComment); ok {
+		return slices.Contains(markers, comment.Body)
+	}
+	if !slices.ContainsFunc(markers, func(marker string) bool { return bytes.Contains(line, []byte(marker)) }) {
+		return false
+	}
+	var text struct {
+		Selftext string `json:"selftext"`
+	}
+	return json.Unmarshal(line, &text) == nil && slices.Contains(markers, text.Selftext)
+}
+
+// subredditSet holds lowercased subreddit names.
+type subredditSet map[string]struct{}
+
+func newSubredditSet(names []string) subredditSet {
+	set := make(subredditSet, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			set[name] = struct{}{}
+		}
+	}
+	return set
+}
+
+func (s subredditSet) contains(name string) bool {
+	_, ok := s[strings.ToLower(name)]
+	return ok
+}
+
+// subredditAllowed matches the raw subreddit name, before sanitization,
+// against the Include and Exclude lists. An empty Include list allows
+// everything.
+func (p *Processor) subredditAllowed(name string) bool {
+	if len(p.include) > 0 && !p.include.contains(name) {
+		return false
+	}
+	return !p.exclude.contains(name)
+}
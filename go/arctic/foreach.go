@@ -0,0 +1,116 @@
+package arctic
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ForEachPost decodes the dump at path and calls fn with every post and its
+// JSON, which fn may keep. Comments are passed as a RedditPost too, without
+// a domain; their JSON can be unmarshalled into a RedditComment for the
+// rest. opts is validated and normalized as by NewProcessor, and the parts
+// of it that apply to reading a dump are used: the filters, Limit and
+// Sample, Transformers, Fields, FastDecode, WorkersPerFile,
+// DetectRecordType, MaxLineSize, RecordDelimiter and the file progress.
+// Unparseable lines are skipped and logged as a count. An error returned by
+// fn stops the iteration and is returned as is; cancelling ctx stops it
+// with ErrInterrupted.
+func ForEachPost(ctx context.Context, path string, opts Options, fn func(RedditPost, []byte) error) error {
+	opts, err := normalizeOptions(opts)
+	if err != nil {
+		return err
+	}
+	p := &Processor{
+		opts:    opts,
+		include: newSubredditSet(opts.Include),
+		exclude: newSubredditSet(opts.Exclude),
+		log:     opts.Logger,
+	}
+
+	kind, monthYear := parseDumpFilename(filepath.Base(path), opts.NamePattern)
+	if opts.DetectRecordType {
+		kind = mixedDump
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening file %s: %v", path, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("error getting file info for %s: %v", path, err)
+	}
+	compressed := &countingReader{r: file}
+	zReader, err := zstd.NewReader(compressed, dumpDecoderOptions(opts)...)
+	if err != nil {
+		return fmt.Errorf("error creating zstd reader for file %s: %v", path, err)
+	}
+	defer zReader.Close()
+
+	progressLog := newProgressLog(filepath.Base(path), info.Size(), &compressed.n)
+	progressLog.lineLimit = opts.Limit
+	progressLog.hidden = !opts.FileProgress
+	progressLog.updateInterval = opts.ProgressInterval
+
+	lines, err := newRecordReader(contextReader{ctx: ctx, r: zReader}, bufferSize, opts.MaxLineSize, opts.RecordDelimiter)
+	if err != nil {
+		return err
+	}
+	scanner := p.newLineScanner(lines, kind, monthYear)
+	defer scanner.close()
+	var unparseable int64
+	for ctx.Err() == nil {
+		sl, ok := scanner.next()
+		if !ok {
+			break
+		}
+		progressLog.OnLine(sl.size)
+		switch {
+		case sl.blank:
+			continue
+		case sl.err != nil:
+			unparseable++
+			continue
+		case sl.sampledOut || sl.filtered || sl.dropped || sl.deleted ||
+			!p.inDateRange(sl.record.createdUTC()) || !p.subredditAllowed(sl.record.subredditName()):
+			progressLog.OnSkippedRow()
+			continue
+		}
+		progressLog.OnRow()
+		if err := fn(asPost(sl.record), sl.record.rawJSON()); err != nil {
+			return err
+		}
+	}
+	progressLog.LogProgress("\n")
+
+	scanner.close()
+	if unparseable > 0 {
+		p.log.Warn("unparseable lines", "path", path, "count", unparseable)
+	}
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
+	if err := lines.Err(); err != nil {
+		return fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return nil
+}
+
+// asPost returns record as a RedditPost.
+func asPost(record Record) RedditPost {
+	if post, ok := record.(RedditPost); ok {
+		return post
+	}
+	return RedditPost{
+		ID:         record.id(),
+		Subreddit:  record.subredditName(),
+		Author:     record.authorName(),
+		Domain:     record.domainName(),
+		CreatedUTC: record.createdUTC(),
+		Raw:        record.rawJSON(),
+	}
+}
@@ -0,0 +1,43 @@
+package arctic
+
+import (
+	"context"
+	"testing"
+)
+
+func TestForEachPostNormalizesOptions(t *testing.T) {
+	path := writeDump(t, t.TempDir(), "RC_2023-01.zst",
+		`{"id":"a","subreddit":"golang","author":"gopher","created_utc":1672531200,"body":"hi","link_id":"t3_x"}`,
+		`{"id":"b","subreddit":"golang","author":"[deleted]","created_utc":1672531201,"body":"[deleted]","link_id":"t3_x"}`,
+	)
+	// DropDeleted without markers uses the default ones, as in NewProcessor
+	opts := DefaultOptions()
+	opts.DropDeleted = true
+	opts.DeletedMarkers = nil
+	opts.Quiet = true
+
+	var posts []RedditPost
+	err := ForEachPost(context.Background(), path, opts, func(post RedditPost, raw []byte) error {
+		posts = append(posts, post)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 {
+		t.Fatalf("got %d posts, want the one that isn't deleted", len(posts))
+	}
+	if post := posts[0]; post.ID != "a" || post.Subreddit != "golang" || post.Author != "gopher" || post.CreatedUTC != 1672531200 || len(post.Raw) == 0 {
+		t.Errorf("got %+v", post)
+	}
+}
+
+func TestForEachPostRejectsInvalidOptions(t *testing.T) {
+	path := writeDump(t, t.TempDir(), "RS_2023-01.zst", post("golang", "a", 1672531200))
+	opts := DefaultOptions()
+	opts.Limit = -1
+	err := ForEachPost(context.Background(), path, opts, func(RedditPost, []byte) error { return nil })
+	if err == nil {
+		t.Fatal("invalid options were accepted")
+	}
+}
@@ -0,0 +1,120 @@
+package arctic
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	frameMagic     = 0xFD2FB528
+	skippableMagic = 0x184D2A50 // the low 4 bits may be anything
+)
+
+// frameCounter counts the zstd frames of a dump from its compressed bytes as
+// they are written to it, walking the frame and block headers next to the
+// decoder. Dumps can be several frames concatenated, which the decoder reads
+// as one stream; the count makes it visible if a run stopped short of some
+// of them. Skippable frames aren't counted, and a frame counts once its last
+// block starts.
+type frameCounter struct {
+	frames int64
+	err    error // the first invalid header, after which nothing is counted
+
+	state    frameField
+	need     int    // length of the field being read
+	buf      []byte // what was read of it
+	skip     int64  // bytes to pass over before it
+	checksum bool   // whether the current frame ends with a checksum
+}
+
+type frameField int
+
+const (
+	fieldMagic frameField = iota
+	fieldSkippableSize
+	fieldDescriptor
+	fieldHeader
+	fieldBlock
+)
+
+func newFrameCounter() *frameCounter {
+	return &frameCounter{state: fieldMagic, need: 4}
+}
+
+// Write never fails, so the counter can't stop the input from being read.
+func (fc *frameCounter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 && fc.err == nil {
+		if fc.skip > 0 {
+			skipped := min(fc.skip, int64(len(p)))
+			fc.skip -= skipped
+			p = p[skipped:]
+			continue
+		}
+		take := min(fc.need-len(fc.buf), len(p))
+		fc.buf = append(fc.buf, p[:take]...)
+		p = p[take:]
+		for fc.err == nil && fc.skip == 0 && len(fc.buf) == fc.need {
+			fc.field()
+		}
+	}
+	return n, nil
+}
+
+// field handles the field in buf and sets up the next one.
+func (fc *frameCounter) field() {
+	field := fc.buf
+	fc.buf = fc.buf[:0]
+	switch fc.state {
+	case fieldMagic:
+		switch magic := binary.LittleEndian.Uint32(field); {
+		case magic == frameMagic:
+			fc.state, fc.need = fieldDescriptor, 1
+		case magic&^0xF == skippableMagic:
+			fc.state, fc.need = fieldSkippableSize, 4
+		default:
+			fc.err = fmt.Errorf("invalid frame magic %#x", magic)
+		}
+
+	case fieldSkippableSize:
+		fc.skip = int64(binary.LittleEndian.Uint32(field))
+		fc.state, fc.need = fieldMagic, 4
+
+	case fieldDescriptor:
+		// The rest of the header: the window descriptor unless the frame is
+		// a single segment, the dictionary ID and the content size
+		descriptor := field[0]
+		singleSegment := descriptor&0x20 != 0
+		fc.checksum = descriptor&0x04 != 0
+		size := []int{0, 1, 2, 4}[descriptor&3] + []int{0, 2, 4, 8}[descriptor>>6]
+		if !singleSegment {
+			size++
+		} else if descriptor>>6 == 0 {
+			size++ // single segments always have a content size
+		}
+		fc.state, fc.need = fieldHeader, size
+
+	case fieldHeader:
+		fc.state, fc.need = fieldBlock, 3
+
+	case fieldBlock:
+		// The last block flag, the block type and the size
+		h := uint32(field[0]) | uint32(field[1])<<8 | uint32(field[2])<<16
+		switch (h >> 1) & 3 {
+		case 1: // RLE, a single byte repeated
+			fc.skip = 1
+		case 3:
+			fc.err = fmt.Errorf("invalid block type in frame %d", fc.frames+1)
+			return
+		default:
+			fc.skip = int64(h >> 3)
+		}
+		if h&1 == 1 {
+			fc.frames++
+			if fc.checksum {
+				fc.skip += 4
+			}
+			fc.state, fc.need = fieldMagic, 4
+		}
+	}
+}
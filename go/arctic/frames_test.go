@@ -0,0 +1,150 @@
+package arctic
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// twoFrameDump returns a dump of two zstd frames with a skippable frame
+// between them, and the posts in it.
+func twoFrameDump(t *testing.T) ([]byte, int) {
+	t.Helper()
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderCRC(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var first, second strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&first, `{"id":"a%d","subreddit":"golang","created_utc":1672531200}`+"\n", i)
+		fmt.Fprintf(&second, `{"id":"b%d","subreddit":"golang","created_utc":1672531200}`+"\n", i)
+	}
+	dump := encoder.EncodeAll([]byte(first.String()), nil)
+	dump = binary.LittleEndian.AppendUint32(dump, skippableMagic|3)
+	dump = binary.LittleEndian.AppendUint32(dump, 5)
+	dump = append(dump, "hello"...)
+	dump = encoder.EncodeAll([]byte(second.String()), dump)
+	return dump, 200
+}
+
+func TestFrameCounter(t *testing.T) {
+	dump, _ := twoFrameDump(t)
+
+	whole := newFrameCounter()
+	whole.Write(dump)
+	if whole.err != nil || whole.frames != 2 {
+		t.Errorf("counted %d frames in one write, err %v, want 2", whole.frames, whole.err)
+	}
+
+	bytewise := newFrameCounter()
+	for i := range dump {
+		bytewise.Write(dump[i : i+1])
+	}
+	if bytewise.err != nil || bytewise.frames != 2 {
+		t.Errorf("counted %d frames byte by byte, err %v, want 2", bytewise.frames, bytewise.err)
+	}
+
+	garbage := newFrameCounter()
+	garbage.Write([]byte("not a zstd frame"))
+	if garbage.err == nil {
+		t.Error("no error for an invalid frame")
+	}
+}
+
+func TestProcessFileReadsEveryFrame(t *testing.T) {
+	dump, posts := twoFrameDump(t)
+	opts := DefaultOptions()
+	opts.OutputCompression = CompressionNone
+	p := newTestProcessor(t, opts)
+	path := filepath.Join(p.opts.InputDir, "RS_2023-01.zst")
+	if err := os.WriteFile(path, dump, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.ProcessFile(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	lines := readLines(t, filepath.Join(p.opts.OutputDir, "2023-01", "golang.jsonl"))
+	if len(lines) != posts {
+		t.Fatalf("got %d posts, want %d", len(lines), posts)
+	}
+	if !strings.Contains(lines[posts-1], `"b99"`) {
+		t.Errorf("last post is %s, want the last of the second frame", lines[posts-1])
+	}
+}
+
+// writeLargeWindowDump writes lines as the dump name in dir, compressed as a
+// stream with a window of window bytes, and returns its path.
+func writeLargeWindowDump(tb testing.TB, dir, name string, window int, lines ...string) string {
+	tb.Helper()
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer file.Close()
+	encoder, err := zstd.NewWriter(file, zstd.WithWindowSize(window))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, line := range lines {
+		if _, err := io.WriteString(encoder, line+"\n"); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+func TestProcessFileLargeWindowRestrictedMemory(t *testing.T) {
+	const window = 32 << 20
+	var lines []string
+	// Enough posts for the encoder to stream them instead of writing a
+	// single segment frame, which only needs a window of its size
+	for i := range 5000 {
+		lines = append(lines, post("golang", fmt.Sprint(i), 1672531200+int64(i)))
+	}
+	tests := []struct {
+		name      string
+		maxMemory uint64
+		ok        bool
+	}{
+		{"memory above the window", 2 * window, true},
+		{"memory at the window", window, true},
+		{"memory below the window", window / 4, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.OutputCompression = CompressionNone
+			opts.DecoderConcurrency = 1
+			opts.DecoderMaxMemory = tt.maxMemory
+			p := newTestProcessor(t, opts)
+			path := writeLargeWindowDump(t, p.opts.InputDir, "RS_2023-01.zst", window, lines...)
+			err := p.ProcessFile(context.Background(), path)
+			if !tt.ok {
+				if err == nil || errors.Is(err, ErrCorruptInput) || !strings.Contains(err.Error(), "max memory") {
+					t.Errorf("got %v, want an error about the decoder memory", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readLines(t, filepath.Join(p.opts.OutputDir, "2023-01", "golang.jsonl")); !slices.Equal(got, lines) {
+				t.Errorf("got %d posts, want all %d in order", len(got), len(lines))
+			}
+		})
+	}
+}
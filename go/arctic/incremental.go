@@ -0,0 +1,70 @@
+package arctic
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// claimMonth decides in Incremental mode whether the dump at path may write
+// to its month's output directory, and records it as started.
+//
+// A month directory the manifest knows nothing about predates incremental
+// processing and is left untouched, and so the dump is skipped with
+// ErrAlreadyProcessed. If the dump was started before, e.g. by an
+// interrupted attempt, the files it wrote are removed so it starts over
+// instead of writing duplicates. Files other dumps of the month wrote to as
+// well are kept and appended to.
+//
+// Finished months are compressed, and compression only picks up .jsonl and
+// .csv files, so skipped months are never decompressed or rewritten. A new
+// dump of a month other dumps already wrote to is merged into their
+// compressed files, see Options.Incremental.
+func (p *Processor) claimMonth(path string, size int64, month string) error {
+	p.monthMu.Lock()
+	defer p.monthMu.Unlock()
+
+	dir := filepath.Join(p.opts.OutputDir, month)
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		files := p.manifest.monthFiles(month)
+		switch {
+		case len(files) == 0:
+			return fmt.Errorf("%w: output for %s already exists", ErrAlreadyProcessed, month)
+		case slices.Contains(files, filepath.Base(path)):
+			p.log.Info("restarting a file processed before, removing its output", "path", path, "month", month)
+			if err := p.removeDumpOutput(path); err != nil {
+				return err
+			}
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error checking output directory %s: %v", dir, err)
+	}
+
+	if err := p.manifest.markStarted(path, size, month); err != nil {
+		return fmt.Errorf("error updating progress manifest: %v", err)
+	}
+	return nil
+}
+
+// removeDumpOutput removes the output files the manifest recorded for the
+// dump at path, compressed or not, leaving those other dumps wrote to alone.
+func (p *Processor) removeDumpOutput(path string) error {
+	own, shared := p.manifest.outputFiles(path)
+	if len(shared) > 0 {
+		p.log.Warn("resuming a file whose output is shared with other files, its posts may be duplicated", "path", path, "files", len(shared))
+	}
+	for _, rel := range own {
+		// Whichever compression earlier runs used
+		file := filepath.Join(p.opts.OutputDir, filepath.FromSlash(rel))
+		zst := CompressionZstd.compressedName(file, p.opts.Format)
+		gz := CompressionGzip.compressedName(file, p.opts.Format)
+		for _, name := range []string{file, zst, seekIndexName(zst), gz} {
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("error removing partial output %s: %v", name, err)
+			}
+		}
+		p.index.remove(p.relCompressedPath(file))
+	}
+	return nil
+}
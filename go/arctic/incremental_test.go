@@ -0,0 +1,124 @@
+package arctic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestClaimMonthRemovesOnlyTheDumpsFiles(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Incremental = true
+	p := newTestProcessor(t, opts)
+	submissions := writeDump(t, p.opts.InputDir, "RS_2023-01.zst",
+		post("golang", "a", 1672531200),
+		post("golang", "b", 1672531201),
+	)
+	comments := writeDump(t, p.opts.InputDir, "RC_2023-01.zst",
+		`{"id":"c","subreddit":"golang","created_utc":1672531202,"body":"hi","link_id":"t3_a"}`,
+	)
+	for _, path := range []string{submissions, comments} {
+		if err := p.ProcessFile(context.Background(), path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Written to the month by something else
+	other := filepath.Join(p.opts.OutputDir, "2023-01", "other.zst")
+	if err := os.WriteFile(other, []byte("not ours"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reprocessing claims the month again and starts the dump over
+	opts = p.opts
+	opts.Force = true
+	p = newTestProcessor(t, opts)
+	if err := p.ProcessFile(context.Background(), submissions); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, want := range map[string]int{
+		filepath.Join("2023-01", "golang.jsonl"):             2,
+		filepath.Join("2023-01", "comments", "golang.jsonl"): 1,
+	} {
+		if lines := readLines(t, filepath.Join(p.opts.OutputDir, file)); len(lines) != want {
+			t.Errorf("got %d posts in %s after the restart, want %d", len(lines), file, want)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("file of another dump removed: %v", err)
+	}
+}
+
+func TestRunTwiceKeepsIndexCounts(t *testing.T) {
+	for _, incremental := range []bool{false, true} {
+		t.Run(fmt.Sprintf("incremental=%v", incremental), func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.Incremental = incremental
+			p := newTestProcessor(t, opts)
+			writeDump(t, p.opts.InputDir, "RS_2023-01.zst",
+				post("golang", "a", 1672531200),
+				post("golang", "b", 1672531201),
+				post("rust", "c", 1672531202),
+			)
+
+			var first []byte
+			for run := 0; run < 2; run++ {
+				// The rerun overwrites the compressed files
+				opts := p.opts
+				opts.Force = run > 0
+				p := newTestProcessor(t, opts)
+				if _, err := p.Run(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				data, err := os.ReadFile(filepath.Join(p.opts.OutputDir, indexName))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if run == 0 {
+					first = data
+				} else if string(data) != string(first) {
+					t.Errorf("the rerun changed the index from\n%s\nto\n%s", first, data)
+				}
+			}
+
+			var idx outputIndex
+			if err := json.Unmarshal(first, &idx); err != nil {
+				t.Fatal(err)
+			}
+			entry := idx.Partitions["2023-01"]["golang"]
+			if entry == nil || entry.Posts != 2 || entry.FirstCreatedUTC != 1672531200 || entry.LastCreatedUTC != 1672531201 {
+				t.Errorf("got index entry %+v, want 2 posts from 1672531200 to 1672531201", entry)
+			}
+		})
+	}
+}
+
+func TestRunIncrementalMergesAnotherDumpOfTheMonth(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Incremental = true
+	opts.NamePattern = regexp.MustCompile(`^RS_(?P<month>\d{4}-\d{2})`)
+	p := newTestProcessor(t, opts)
+	early, late := post("golang", "a", 1672531200), post("golang", "b", 1672617600)
+	writeDump(t, p.opts.InputDir, "RS_2023-01.zst", early)
+	if _, err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A dump of the same month arriving after it was compressed
+	writeDump(t, p.opts.InputDir, "RS_2023-01_late.zst", late)
+	p = newTestProcessor(t, p.opts)
+	if _, err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	compressed := filepath.Join(p.opts.OutputDir, "2023-01", "golang.zst")
+	if got, want := readCompressed(t, p, compressed), []string{early, late}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,194 @@
+package arctic
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const indexName = "index.json"
+
+// indexEntry describes one output file. Paths are relative to the output
+// directory and use forward slashes.
+type indexEntry struct {
+	File            string `json:"file"`  // the compressed file
+	Posts           int64  `json:"posts"` // posts written to the file so far
+	Bytes           int64  `json:"bytes"` // uncompressed size
+	CompressedBytes int64  `json:"compressed_bytes,omitempty"`
+	SHA256          string `json:"sha256,omitempty"` // of the compressed file
+
+	// The created_utc of the oldest and newest post in the file, leaving out
+	// posts without one, so the time span is known without reading it
+	FirstCreatedUTC float64 `json:"first_created_utc,omitempty"`
+	LastCreatedUTC  float64 `json:"last_created_utc,omitempty"`
+
+	// What this run appended since the file was last compressed
+	added                 int64
+	addedFirst, addedLast float64
+}
+
+// createdRange returns the oldest and newest created_utc of records, 0 for
+// both if none has one.
+func createdRange(records []Record) (first, last float64) {
+	for _, record := range records {
+		created := record.createdUTC()
+		if created == 0 {
+			continue
+		}
+		if first == 0 || created < first {
+			first = created
+		}
+		last = max(last, created)
+	}
+	return first, last
+}
+
+// outputIndex lists every output file by partition (usually the month) and
+// subreddit, so downstream tools can discover the data without walking the
+// tree. It is updated as chunks are written, shared by all workers and
+// saved like the progress manifest.
+type outputIndex struct {
+	mu         sync.Mutex
+	path       string
+	perm       permissions
+	Partitions map[string]map[string]*indexEntry `json:"partitions"`
+	byFile     map[string]*indexEntry
+	dirty      bool
+}
+
+func loadOutputIndex(dir string, perm permissions) (*outputIndex, error) {
+	idx := &outputIndex{
+		path:       filepath.Join(dir, indexName),
+		perm:       perm,
+		Partitions: make(map[string]map[string]*indexEntry),
+		byFile:     make(map[string]*indexEntry),
+	}
+
+	data, err := os.ReadFile(idx.path)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading index %s: %v", idx.path, err)
+	}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("error parsing index %s: %v", idx.path, err)
+	}
+	if idx.Partitions == nil {
+		idx.Partitions = make(map[string]map[string]*indexEntry)
+	}
+	for _, entries := range idx.Partitions {
+		for _, entry := range entries {
+			idx.byFile[entry.File] = entry
+		}
+	}
+	return idx, nil
+}
+
+// add counts posts and bytes appended to the output file of a subreddit in a
+// partition, and widens its time span to first and last, unless they are 0.
+// file is the compressed file's path relative to the output directory.
+func (idx *outputIndex) add(partition, subreddit, file string, posts, bytes int64, first, last float64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	file = filepath.ToSlash(file)
+
+	entries, ok := idx.Partitions[partition]
+	if !ok {
+		entries = make(map[string]*indexEntry)
+		idx.Partitions[partition] = entries
+	}
+	entry, ok := entries[subreddit]
+	if !ok {
+		entry = &indexEntry{File: file}
+		entries[subreddit] = entry
+		idx.byFile[file] = entry
+	}
+	entry.Posts += posts
+	entry.Bytes += bytes
+	entry.FirstCreatedUTC, entry.LastCreatedUTC = widen(entry.FirstCreatedUTC, entry.LastCreatedUTC, first, last)
+	entry.added += posts
+	entry.addedFirst, entry.addedLast = widen(entry.addedFirst, entry.addedLast, first, last)
+	idx.dirty = true
+}
+
+// widen returns the time span first to last extended by the one of other
+// posts, where 0 stands for none.
+func widen(first, last, otherFirst, otherLast float64) (float64, float64) {
+	if otherFirst != 0 && (first == 0 || otherFirst < first) {
+		first = otherFirst
+	}
+	return first, max(last, otherLast)
+}
+
+// setCompressed records a compressed file with the posts and uncompressed
+// bytes it holds, which replace the counts added up so far: a file
+// overwritten by a rerun only holds the posts of the rerun. Unless the file
+// was merged with the existing one, the time span is narrowed to the posts
+// added since it was last compressed if those are all it holds.
+func (idx *outputIndex) setCompressed(file string, posts, bytes, size int64, checksum string, merged bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if entry, ok := idx.byFile[filepath.ToSlash(file)]; ok {
+		if !merged && posts == entry.added {
+			entry.FirstCreatedUTC, entry.LastCreatedUTC = entry.addedFirst, entry.addedLast
+		}
+		entry.Posts = posts
+		entry.Bytes = bytes
+		entry.CompressedBytes = size
+		entry.SHA256 = checksum
+		entry.added, entry.addedFirst, entry.addedLast = 0, 0, 0
+		idx.dirty = true
+	}
+}
+
+// remove drops the entry of a file that was deleted.
+func (idx *outputIndex) remove(file string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	file = filepath.ToSlash(file)
+	if _, ok := idx.byFile[file]; !ok {
+		return
+	}
+	delete(idx.byFile, file)
+	for partition, entries := range idx.Partitions {
+		for subreddit, entry := range entries {
+			if entry.File == file {
+				delete(entries, subreddit)
+			}
+		}
+		if len(entries) == 0 {
+			delete(idx.Partitions, partition)
+		}
+	}
+	idx.dirty = true
+}
+
+// save writes the index if it changed, using a temporary file and a rename.
+func (idx *outputIndex) save() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if !idx.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding index: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(idx.path), idx.perm.dir); err != nil {
+		return fmt.Errorf("error creating directory %s: %v", filepath.Dir(idx.path), err)
+	}
+
+	tmpPath := idx.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, idx.perm.file); err != nil {
+		return fmt.Errorf("error writing index %s: %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, idx.path); err != nil {
+		return fmt.Errorf("error replacing index %s: %v", idx.path, err)
+	}
+	idx.dirty = false
+	return nil
+}
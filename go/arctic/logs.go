@@ -0,0 +1,526 @@
+package arctic
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+// progressTerminal is set when stdout is a terminal. Otherwise, e.g. when
+// it's redirected to a file, progress lines can't be overwritten and every
+// update is printed as a line of its own.
+var progressTerminal = isTerminal(os.Stdout)
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// progressWidth is the width of the terminal in columns, 0 if unknown. It's
+// read once progress is first printed and again whenever the terminal is
+// resized, where the OS signals that.
+var (
+	progressWidth  atomic.Int64
+	watchWidthOnce sync.Once
+)
+
+// refreshProgressWidth reads the terminal width, keeping the last one known
+// if that fails.
+func refreshProgressWidth() {
+	if width, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+		progressWidth.Store(int64(width))
+	}
+}
+
+// fitProgressLine cuts line to one column less than the terminal is wide. A
+// line that wraps can't be overwritten with a carriage return, which only
+// goes back to the start of the last row, so the rows above would pile up.
+func fitProgressLine(line string) string {
+	width := int(progressWidth.Load())
+	if width <= 0 {
+		return line
+	}
+	columns := 0
+	for i, r := range line {
+		columns += runeWidth(r)
+		if columns > width-1 {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// clearableLen is the part of a previous progress line of n columns that is
+// still on its row after the terminal became narrower.
+func clearableLen(n int) int {
+	if width := int(progressWidth.Load()); width > 0 {
+		return min(n, width-1)
+	}
+	return n
+}
+
+// displayWidth returns the columns s takes up on a terminal.
+func displayWidth(s string) int {
+	columns := 0
+	for _, r := range s {
+		columns += runeWidth(r)
+	}
+	return columns
+}
+
+// runeWidth returns the columns r takes up on a terminal: none for combining
+// marks and format characters, two for East Asian wide and fullwidth
+// characters and emoji, and one otherwise.
+func runeWidth(r rune) int {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case r >= 0x1100 && r <= 0x115F, // Hangul Jamo
+		r >= 0x2E80 && r <= 0x303E, // CJK radicals and punctuation
+		r >= 0x3041 && r <= 0x33FF, // kana and CJK compatibility
+		r >= 0x3400 && r <= 0x4DBF, // CJK extension A
+		r >= 0x4E00 && r <= 0x9FFF, // CJK unified ideographs
+		r >= 0xA000 && r <= 0xA4CF, // Yi
+		r >= 0xAC00 && r <= 0xD7A3, // Hangul syllables
+		r >= 0xF900 && r <= 0xFAFF, // CJK compatibility ideographs
+		r >= 0xFE30 && r <= 0xFE4F, // CJK compatibility forms
+		r >= 0xFF00 && r <= 0xFF60, // fullwidth forms
+		r >= 0xFFE0 && r <= 0xFFE6,
+		r >= 0x1F300 && r <= 0x1F64F, // emoji
+		r >= 0x1F900 && r <= 0x1F9FF,
+		r >= 0x20000 && r <= 0x3FFFD: // CJK extensions B and later
+		return 2
+	}
+	return 1
+}
+
+// defaultProgressInterval is the progress update interval used unless one is
+// configured.
+func defaultProgressInterval() time.Duration {
+	if progressTerminal {
+		return 100 * time.Millisecond
+	}
+	return 10 * time.Second
+}
+
+// printMu serializes progress output so lines from concurrent workers don't
+// get spliced into each other. progressLineLen is the length of the progress
+// line currently on screen, 0 when the cursor is at the start of a line.
+var (
+	printMu         sync.Mutex
+	progressLineLen int
+)
+
+// progressAwareWriter clears an unfinished progress line before passing
+// writes through, so log output never gets appended to a progress bar.
+type progressAwareWriter struct {
+	w io.Writer
+}
+
+// NewProgressAwareWriter wraps w, typically the log output, so writes to it
+// don't garble the progress lines printed to stdout.
+func NewProgressAwareWriter(w io.Writer) io.Writer {
+	return progressAwareWriter{w: w}
+}
+
+func (pw progressAwareWriter) Write(b []byte) (int, error) {
+	printMu.Lock()
+	defer printMu.Unlock()
+	if progressLineLen > 0 {
+		fmt.Printf("\r%*s\r", clearableLen(progressLineLen), "")
+		progressLineLen = 0
+	}
+	return pw.w.Write(b)
+}
+
+// printProgress prints line over the current progress line, padding it to
+// wipe a longer one. The line stays on screen when end is a newline and is
+// overwritten by the next progress or log output otherwise. Without a
+// terminal, every line ends in a newline. On a terminal, lines are cut to
+// its width.
+func printProgress(line, end string) {
+	printMu.Lock()
+	defer printMu.Unlock()
+	if !progressTerminal {
+		fmt.Println(strings.TrimRight(line, " "))
+		return
+	}
+	watchWidthOnce.Do(watchProgressWidth)
+	line = fitProgressLine(line)
+	// Padded by columns, as fmt pads by runes
+	pad := max(clearableLen(progressLineLen)-displayWidth(line), 0)
+	fmt.Printf("\r%s%s%s", line, strings.Repeat(" ", pad), end)
+	if end == "" {
+		progressLineLen = displayWidth(line)
+	} else {
+		progressLineLen = 0
+	}
+}
+
+// FileProgressLog prints the progress of a single input file. Progress is
+// measured in compressed bytes handed to the decoder against the size of the
+// file, so it only ever grows and stays within [0, 1]. The decoder reads
+// ahead, which puts the percentage slightly ahead of the rows processed.
+type FileProgressLog struct {
+	name           string
+	read           *atomic.Int64
+	fileSize       int64
+	i              int64
+	skipped        int64
+	startTime      time.Time
+	maxLineLength  int
+	lastUpdate     time.Time
+	updateInterval time.Duration
+
+	// readLimit is the MB/s reads are throttled to, shown next to the
+	// throughput. 0 if they aren't.
+	readLimit float64
+
+	// paused marks the line while the worker waits for Options.PauseFile
+	// to be removed.
+	paused bool
+
+	// hidden suppresses every line, finalOnly the in-flight updates so they
+	// don't fight with the total progress line.
+	hidden    bool
+	finalOnly bool
+
+	// lineLimit is the number of lines that will be read at most, 0 if the
+	// whole input is read. Progress is measured against it when it's closer
+	// than the end of the input.
+	lines     int64
+	lineLimit int64
+
+	// estimateRows adds the progress in rows against an estimate of the
+	// lines in the input, see estimatedRows.
+	estimateRows bool
+
+	// Decompressed bytes and their throughput, smoothed over
+	// throughputWindow
+	bytes          int64
+	rateBytes      int64
+	rateTime       time.Time
+	bytesPerSecond float64
+}
+
+// throughputWindow is the time constant of the moving average the MB/s figure
+// is smoothed with, so bursty decoder reads don't make it jump around.
+const throughputWindow = 2 * time.Second
+
+// NewFileProgressLog creates the progress log for path. read counts the
+// compressed bytes consumed so far.
+func NewFileProgressLog(path string, read *atomic.Int64) (*FileProgressLog, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error getting file info: %v", err)
+	}
+	return newProgressLog(filepath.Base(path), fileInfo.Size(), read), nil
+}
+
+// newProgressLog creates a progress log for an input of size bytes, 0 if the
+// size is unknown.
+func newProgressLog(name string, size int64, read *atomic.Int64) *FileProgressLog {
+	return &FileProgressLog{
+		name:           name,
+		read:           read,
+		fileSize:       size,
+		i:              0,
+		startTime:      time.Now(),
+		maxLineLength:  0,
+		lastUpdate:     time.Now(),
+		updateInterval: defaultProgressInterval(),
+		rateTime:       time.Now(),
+	}
+}
+
+// OnLine counts a line of n decompressed bytes read, whether or not its row
+// is kept.
+func (fpl *FileProgressLog) OnLine(n int) {
+	fpl.lines++
+	fpl.bytes += int64(n)
+}
+
+func (fpl *FileProgressLog) OnRow() {
+	fpl.i++
+	if time.Since(fpl.lastUpdate) >= fpl.updateInterval {
+		fpl.LogProgress("")
+		fpl.lastUpdate = time.Now()
+	}
+}
+
+// OnSkippedRow counts a row that was read but filtered out.
+func (fpl *FileProgressLog) OnSkippedRow() {
+	fpl.skipped++
+	fpl.OnRow()
+}
+
+// position returns how many compressed bytes were consumed, capped at the
+// file size in case the file grew since it was opened.
+func (fpl *FileProgressLog) position() int64 {
+	if fpl.fileSize == 0 {
+		return 0
+	}
+	return min(fpl.read.Load(), fpl.fileSize)
+}
+
+// progress returns the fraction of the input read, in [0, 1]: the compressed
+// bytes consumed against the file size, or the lines read against the line
+// limit when that's further along.
+func (fpl *FileProgressLog) progress() float64 {
+	var progress float64
+	if fpl.fileSize > 0 {
+		progress = float64(fpl.position()) / float64(fpl.fileSize)
+	}
+	if fpl.lineLimit > 0 {
+		progress = max(progress, min(float64(fpl.lines)/float64(fpl.lineLimit), 1))
+	}
+	return progress
+}
+
+func (fpl *FileProgressLog) LogProgress(end string) {
+	if fpl.hidden || (fpl.finalOnly && end == "") {
+		return
+	}
+	progress := fpl.progress()
+	elapsed := time.Since(fpl.startTime)
+	var remaining time.Duration
+	if progress > 0 {
+		remaining = time.Duration(float64(elapsed)/progress) - elapsed
+	}
+	var timePerRow time.Duration
+	if fpl.i > 0 {
+		timePerRow = elapsed / time.Duration(fpl.i)
+	}
+
+	// The final line shows the average over the whole file
+	throughput := fpl.throughput()
+	if end != "" && elapsed > 0 {
+		throughput = float64(fpl.bytes) / elapsed.Seconds()
+	}
+
+	printStr := fmt.Sprintf("%s: %d (%d skipped) - %.2f%% - elapsed: %s - remaining: %s - %s/row - %.2f MB/s",
+		fpl.name, fpl.i, fpl.skipped, progress*100, formatTime(elapsed), formatTime(remaining), formatTime(timePerRow),
+		megabytes(int64(throughput)))
+	if fpl.fileSize == 0 && fpl.lineLimit == 0 {
+		// Streams of unknown size, nothing to estimate the remaining time on
+		printStr = fmt.Sprintf("%s: %d (%d skipped) - elapsed: %s - %s/row - %.2f MB/s",
+			fpl.name, fpl.i, fpl.skipped, formatTime(elapsed), formatTime(timePerRow), megabytes(int64(throughput)))
+	}
+
+	if fpl.readLimit > 0 {
+		printStr += fmt.Sprintf(" (max %.2f)", fpl.readLimit)
+	}
+	if estimate := fpl.estimatedRows(); fpl.estimateRows && estimate > 0 {
+		printStr += fmt.Sprintf(" - rows: %d/~%d", fpl.lines, estimate)
+	}
+	if fpl.paused {
+		printStr += " - paused"
+	}
+
+	if len(printStr) > fpl.maxLineLength {
+		fpl.maxLineLength = len(printStr)
+	}
+	printProgress(fmt.Sprintf("%-*s", fpl.maxLineLength, printStr), end)
+}
+
+// minEstimateBytes is the compressed input needed before estimatedRows
+// extrapolates from it.
+const minEstimateBytes = 1024 * 1024
+
+// estimatedRows extrapolates the number of lines in the input from the lines
+// read per compressed byte so far, which refines the estimate as more of the
+// file is read. Unlike the byte position, the rows don't run ahead with the
+// decoder's read-ahead. It returns 0 for inputs of unknown size and until
+// minEstimateBytes, or the whole file if it's smaller, were read.
+func (fpl *FileProgressLog) estimatedRows() int64 {
+	position := fpl.position()
+	if fpl.fileSize == 0 || fpl.lines == 0 || position < min(minEstimateBytes, fpl.fileSize) {
+		return 0
+	}
+	estimate := max(int64(float64(fpl.lines)*float64(fpl.fileSize)/float64(position)), fpl.lines)
+	if fpl.lineLimit > 0 {
+		estimate = min(estimate, fpl.lineLimit)
+	}
+	return estimate
+}
+
+// throughput updates the moving average of the decompressed bytes per second
+// with the bytes read since the last call and returns it.
+func (fpl *FileProgressLog) throughput() float64 {
+	now := time.Now()
+	dt := now.Sub(fpl.rateTime)
+	if dt <= 0 {
+		return fpl.bytesPerSecond
+	}
+	current := float64(fpl.bytes-fpl.rateBytes) / dt.Seconds()
+	if fpl.rateBytes == 0 {
+		fpl.bytesPerSecond = current
+	} else {
+		alpha := 1 - math.Exp(-float64(dt)/float64(throughputWindow))
+		fpl.bytesPerSecond += alpha * (current - fpl.bytesPerSecond)
+	}
+	fpl.rateBytes = fpl.bytes
+	fpl.rateTime = now
+	return fpl.bytesPerSecond
+}
+
+// totalProgress renders a single progress line for the whole run, summing
+// the compressed bytes read from every active file against the total size of
+// the input. Files skipped through the manifest count as done but don't add
+// to the throughput the ETA is based on.
+type totalProgress struct {
+	mu            sync.Mutex
+	files         int
+	finished      int
+	failed        int
+	totalBytes    int64
+	doneBytes     int64
+	skippedBytes  int64
+	active        map[*FileProgressLog]struct{}
+	startTime     time.Time
+	maxLineLength int
+	pause         *pauseControl // nil unless Options.PauseFile is set
+}
+
+func newTotalProgress(pause *pauseControl) *totalProgress {
+	return &totalProgress{active: make(map[*FileProgressLog]struct{}), pause: pause}
+}
+
+// reset starts tracking a new run over files.
+func (tp *totalProgress) reset(files []string) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.files = len(files)
+	tp.finished = 0
+	tp.failed = 0
+	tp.totalBytes = 0
+	tp.doneBytes = 0
+	tp.skippedBytes = 0
+	tp.startTime = time.Now()
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			tp.totalBytes += info.Size()
+		}
+	}
+}
+
+func (tp *totalProgress) add(fpl *FileProgressLog) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.active[fpl] = struct{}{}
+}
+
+func (tp *totalProgress) remove(fpl *FileProgressLog) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	delete(tp.active, fpl)
+}
+
+// finish counts a file of size bytes as finished, however it ended, including
+// files that failed or were skipped before they were read. Only the files
+// processed count for the remaining time.
+func (tp *totalProgress) finish(size int64, err error) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.finished++
+	switch {
+	case err == nil:
+		tp.doneBytes += size
+	case errors.Is(err, ErrAlreadyProcessed):
+		tp.skippedBytes += size
+	default:
+		tp.failed++
+		tp.skippedBytes += size
+	}
+}
+
+// start renders the progress line every interval until the returned function
+// is called, which prints the final line.
+func (tp *totalProgress) start(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ticker.C:
+				tp.LogProgress("")
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(quit)
+		wg.Wait()
+		tp.LogProgress("\n")
+	}
+}
+
+func (tp *totalProgress) LogProgress(end string) {
+	tp.mu.Lock()
+	processed := tp.doneBytes
+	for fpl := range tp.active {
+		processed += fpl.position()
+	}
+	done := processed + tp.skippedBytes
+	progress := 0.0
+	if tp.totalBytes > 0 {
+		progress = min(float64(done)/float64(tp.totalBytes), 1)
+	}
+	elapsed := time.Since(tp.startTime)
+	var remaining time.Duration
+	if processed > 0 && done < tp.totalBytes {
+		rate := float64(processed) / float64(elapsed)
+		remaining = time.Duration(float64(tp.totalBytes-done) / rate)
+	}
+
+	printStr := fmt.Sprintf("total: %d/%d files (%d active) - %.2f%% - elapsed: %s - remaining: %s",
+		tp.finished, tp.files, len(tp.active), progress*100, formatTime(elapsed), formatTime(remaining))
+	if tp.failed > 0 {
+		printStr += fmt.Sprintf(" - %d failed", tp.failed)
+	}
+	if tp.pause != nil && tp.pause.isPaused() {
+		printStr += " - paused"
+	}
+	if len(printStr) > tp.maxLineLength {
+		tp.maxLineLength = len(printStr)
+	}
+	tp.mu.Unlock()
+
+	printProgress(fmt.Sprintf("%-*s", tp.maxLineLength, printStr), end)
+}
+
+func formatTime(d time.Duration) string {
+	if d == 0 {
+		return "0s"
+	}
+	if d < time.Microsecond {
+		return fmt.Sprintf("%.1fns", float64(d.Nanoseconds()))
+	}
+	if d < time.Millisecond {
+		return fmt.Sprintf("%.2fµs", float64(d.Microseconds()))
+	}
+	if d < time.Second {
+		return fmt.Sprintf("%.2fms", float64(d.Milliseconds()))
+	}
+	h := d / time.Hour
+	d -= h * time.Hour
+	m := d / time.Minute
+	d -= m * time.Minute
+	s := d / time.Second
+	return fmt.Sprintf("%02d:%02d:%02d", h, m, s)
+}
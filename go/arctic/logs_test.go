@@ -0,0 +1,148 @@
+package arctic
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestFileProgressMonotonicAndBounded(t *testing.T) {
+	var lines []string
+	for i := range 50000 {
+		lines = append(lines, post("golang", string(rune('a'+i%26)), 1672531200+int64(i)))
+	}
+	path := writeDump(t, t.TempDir(), "RS_2023-01.zst", lines...)
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := &countingReader{r: file}
+	zReader, err := zstd.NewReader(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zReader.Close()
+
+	fpl := newProgressLog("RS_2023-01.zst", info.Size(), &compressed.n)
+	fpl.hidden = true
+	lr := newLineReader(zReader, 4096, 0)
+	var last float64
+	for lr.Scan() {
+		fpl.OnLine(len(lr.Bytes()) + 1)
+		progress := fpl.progress()
+		if progress < last || progress < 0 || progress > 1 {
+			t.Fatalf("progress went from %v to %v at line %d", last, progress, fpl.lines)
+		}
+		last = progress
+	}
+	if err := lr.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if last != 1 {
+		t.Errorf("progress is %v at the end of the input, want 1", last)
+	}
+}
+
+func TestFileProgressLineLimit(t *testing.T) {
+	var read atomic.Int64
+	fpl := newProgressLog("stdin", 0, &read)
+	fpl.lineLimit = 10
+	for i := range 20 {
+		fpl.OnLine(1)
+		if progress, want := fpl.progress(), min(float64(i+1)/10, 1); progress != want {
+			t.Fatalf("progress is %v after %d lines, want %v", progress, i+1, want)
+		}
+	}
+}
+
+func TestFileProgressBeforeAnyRow(t *testing.T) {
+	var read atomic.Int64
+	for _, size := range []int64{0, 100} {
+		fpl := newProgressLog("RS_2023-01.zst", size, &read)
+		if progress := fpl.progress(); progress != 0 {
+			t.Errorf("progress of size %d is %v before anything was read, want 0", size, progress)
+		}
+		fpl.LogProgress("\n")
+	}
+}
+
+func TestTotalProgressCountsFailedFiles(t *testing.T) {
+	opts := DefaultOptions()
+	opts.TotalProgress = true
+	p := newTestProcessor(t, opts)
+	good := writeDump(t, p.opts.InputDir, "RS_2023-01.zst", post("golang", "a", 1672531200))
+	// Fails before a line is read
+	gone := filepath.Join(p.opts.InputDir, "RS_2023-02.zst")
+
+	p.total.reset([]string{good, gone})
+	if err := p.ProcessFile(context.Background(), good); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ProcessFile(context.Background(), gone); err == nil {
+		t.Fatal("processed a missing file")
+	}
+	if p.total.finished != 2 || p.total.failed != 1 || len(p.total.active) != 0 {
+		t.Errorf("got %d finished, %d failed and %d active files, want 2, 1 and 0", p.total.finished, p.total.failed, len(p.total.active))
+	}
+	if done := p.total.doneBytes + p.total.skippedBytes; done != p.total.totalBytes {
+		t.Errorf("got %d of %d bytes done", done, p.total.totalBytes)
+	}
+}
+
+func TestFitProgressLine(t *testing.T) {
+	defer progressWidth.Store(progressWidth.Load())
+	long := strings.Repeat("x", 30)
+	tests := []struct {
+		width      int64
+		line, want string
+	}{
+		{10, "short", "short"},
+		{10, "123456789", "123456789"},
+		{10, "1234567890", "123456789"},
+		{10, "12345678é", "12345678é"},  // 9 columns in 10 bytes
+		{10, "12345678éx", "12345678é"}, // cut after the rune, not inside it
+		{10, "123456789é", "123456789"},
+		{10, "日本語テスト", "日本語テ"},        // 2 columns each
+		{10, "12345678日", "12345678"}, // the last rune would take 2 columns
+		{10, "1234567日", "1234567日"},
+		{0, long, long}, // unknown width
+		{1, long, ""},   // no column left to print
+	}
+	for _, tt := range tests {
+		progressWidth.Store(tt.width)
+		if got := fitProgressLine(tt.line); got != tt.want {
+			t.Errorf("fitProgressLine(%q) at width %d = %q, want %q", tt.line, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestClearableLenAfterNarrowing(t *testing.T) {
+	defer progressWidth.Store(progressWidth.Load())
+	tests := []struct {
+		width int64
+		n     int
+		want  int
+	}{
+		{80, 30, 30},
+		{10, 30, 9}, // the rest of the line was cut off when the terminal narrowed
+		{10, 5, 5},
+		{1, 30, 0},
+		{0, 30, 30},
+	}
+	for _, tt := range tests {
+		progressWidth.Store(tt.width)
+		if got := clearableLen(tt.n); got != tt.want {
+			t.Errorf("clearableLen(%d) at width %d = %d, want %d", tt.n, tt.width, got, tt.want)
+		}
+	}
+}
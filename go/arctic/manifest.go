@@ -0,0 +1,173 @@
+package arctic
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const manifestName = ".arctic_progress.json"
+
+type manifestEntry struct {
+	Size        int64     `json:"size"`
+	Month       string    `json:"month,omitempty"`   // the output directory
+	CompletedAt time.Time `json:"completed_at"`      // zero while in progress
+	SHA256      string    `json:"sha256,omitempty"`  // of the dump, once completed
+	Partial     bool      `json:"partial,omitempty"` // timed out, keeping the posts read
+
+	// The uncompressed output files written to in Incremental mode,
+	// relative to the output directory
+	Files []string `json:"files,omitempty"`
+}
+
+// progressManifest records which input files have been fully processed, so a
+// rerun doesn't append the same posts to the output a second time, and which
+// ones were started. Files are keyed by their base name; a changed size
+// invalidates the entry.
+type progressManifest struct {
+	mu    sync.Mutex
+	path  string
+	perm  permissions
+	Files map[string]manifestEntry `json:"files"`
+}
+
+func loadProgressManifest(dir string, perm permissions) (*progressManifest, error) {
+	m := &progressManifest{
+		path:  filepath.Join(dir, manifestName),
+		perm:  perm,
+		Files: make(map[string]manifestEntry),
+	}
+
+	data, err := os.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest %s: %v", m.path, err)
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("error parsing manifest %s: %v", m.path, err)
+	}
+	if m.Files == nil {
+		m.Files = make(map[string]manifestEntry)
+	}
+	return m, nil
+}
+
+func (m *progressManifest) isCompleted(path string, size int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.Files[filepath.Base(path)]
+	return ok && entry.Size == size && !entry.CompletedAt.IsZero()
+}
+
+// markStarted records that path, writing to the output directory month, is
+// being processed.
+func (m *progressManifest) markStarted(path string, size int64, month string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Files[filepath.Base(path)] = manifestEntry{Size: size, Month: month}
+	return m.save()
+}
+
+// addFiles records that path wrote to the output files at paths below
+// outputDir.
+func (m *progressManifest) addFiles(path, outputDir string, paths []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.Files[filepath.Base(path)]
+	if !ok {
+		return nil
+	}
+	for _, file := range paths {
+		rel, err := filepath.Rel(outputDir, file)
+		if err != nil {
+			return fmt.Errorf("error recording output file %s: %v", file, err)
+		}
+		entry.Files = append(entry.Files, filepath.ToSlash(rel))
+	}
+	m.Files[filepath.Base(path)] = entry
+	return m.save()
+}
+
+// outputFiles returns the output files recorded for path, split into those
+// only it wrote to and those other files wrote to as well.
+func (m *progressManifest) outputFiles(path string) (own, shared []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name := filepath.Base(path)
+	others := make(map[string]struct{})
+	for other, entry := range m.Files {
+		if other != name {
+			for _, file := range entry.Files {
+				others[file] = struct{}{}
+			}
+		}
+	}
+	for _, file := range m.Files[name].Files {
+		if _, ok := others[file]; ok {
+			shared = append(shared, file)
+		} else {
+			own = append(own, file)
+		}
+	}
+	return own, shared
+}
+
+// markCompleted records that path was fully processed. checksum is the
+// SHA-256 of the dump.
+func (m *progressManifest) markCompleted(path string, size int64, month, checksum string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	files := m.Files[filepath.Base(path)].Files
+	m.Files[filepath.Base(path)] = manifestEntry{Size: size, Month: month, CompletedAt: time.Now().UTC(), SHA256: checksum, Files: files}
+	return m.save()
+}
+
+// markPartial records that path timed out and only the posts read until then
+// were written, see Options.FlushOnTimeout.
+func (m *progressManifest) markPartial(path string, size int64, month string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	files := m.Files[filepath.Base(path)].Files
+	m.Files[filepath.Base(path)] = manifestEntry{Size: size, Month: month, Partial: true, Files: files}
+	return m.save()
+}
+
+// monthFiles returns the names of the files started or completed for the
+// output directory month.
+func (m *progressManifest) monthFiles(month string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name, entry := range m.Files {
+		if entry.Month == month {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// save writes the manifest to a temporary file and renames it into place, so
+// a crash never leaves a half-written manifest behind. Callers hold m.mu.
+func (m *progressManifest) save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding manifest: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), m.perm.dir); err != nil {
+		return fmt.Errorf("error creating directory %s: %v", filepath.Dir(m.path), err)
+	}
+
+	tmpPath := m.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, m.perm.file); err != nil {
+		return fmt.Errorf("error writing manifest %s: %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, m.path); err != nil {
+		return fmt.Errorf("error replacing manifest %s: %v", m.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package arctic
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// MemoryStats summarize the memory use and garbage collection of a run.
+type MemoryStats struct {
+	// PeakHeapBytes is the largest live heap seen, sampled every
+	// memorySampleInterval, so short spikes between samples can be missed.
+	// HeapSysBytes is the heap memory obtained from the OS at the end,
+	// which the runtime rarely gives back, so it bounds the real peak.
+	PeakHeapBytes uint64 `json:"peak_heap_bytes"`
+	HeapSysBytes  uint64 `json:"heap_sys_bytes"`
+
+	GCCycles      uint32        `json:"gc_cycles"`
+	GCPauseTotal  time.Duration `json:"gc_pause_total_ns"`
+	GCCPUFraction float64       `json:"gc_cpu_fraction"` // of the CPU time available since the program started
+}
+
+// memorySampleInterval is how often the heap is sampled. Reading the memory
+// statistics briefly stops the world, so it's kept infrequent.
+const memorySampleInterval = time.Second
+
+// sampleMemory tracks the peak heap until the returned function is called,
+// which returns the stats of the run so far.
+func sampleMemory() (stop func() MemoryStats) {
+	var start runtime.MemStats
+	runtime.ReadMemStats(&start)
+
+	var mu sync.Mutex
+	peak := start.HeapAlloc
+	ticker := time.NewTicker(memorySampleInterval)
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var m runtime.MemStats
+		for {
+			select {
+			case <-ticker.C:
+				runtime.ReadMemStats(&m)
+				mu.Lock()
+				peak = max(peak, m.HeapAlloc)
+				mu.Unlock()
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return func() MemoryStats {
+		ticker.Stop()
+		close(quit)
+		wg.Wait()
+		var end runtime.MemStats
+		runtime.ReadMemStats(&end)
+		return MemoryStats{
+			PeakHeapBytes: max(peak, end.HeapAlloc),
+			HeapSysBytes:  end.HeapSys,
+			GCCycles:      end.NumGC - start.NumGC,
+			GCPauseTotal:  time.Duration(end.PauseTotalNs - start.PauseTotalNs),
+			GCCPUFraction: end.GCCPUFraction,
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package arctic
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// MergeReport is the result of MergeSubreddits.
+type MergeReport struct {
+	Files   []string `json:"files"` // the merged output files
+	Records int64    `json:"records"`
+}
+
+// MergeSubreddits reassembles the output files of the subreddits matching
+// pattern, across all months, shards and parts, into a single JSONL stream
+// sorted by created_utc, written to output. The output is compressed with
+// zstd or gzip if its name ends in .zst or .gz.
+//
+// pattern is either a subreddit name, mapped to its file name like during
+// processing, or a glob (see path.Match) over the file names, e.g.
+// "Ask*". Only the final files are read, so the output directory has to be
+// compressed with opts.OutputCompression; CSV output can't be merged.
+//
+// The input files don't have to be sorted: they are cut into sorted runs of
+// about ChunkBytes in a temporary file in TmpDir, or next to output, which
+// are then merged like with Sort.
+func MergeSubreddits(ctx context.Context, opts Options, pattern, output string) (*MergeReport, error) {
+	if opts.Format == "" {
+		opts.Format = FormatJSONL
+	}
+	if opts.Format != FormatJSONL {
+		return nil, fmt.Errorf("merging is only supported for %s output", FormatJSONL)
+	}
+	if opts.NameMode == "" {
+		opts.NameMode = NamesASCIIOnly
+	}
+	if opts.OutputCompression == "" {
+		opts.OutputCompression = CompressionZstd
+	}
+	if opts.CompressionLevel == 0 {
+		opts.CompressionLevel = zstd.SpeedDefault
+	}
+	if opts.ChunkBytes <= 0 {
+		opts.ChunkBytes = DefaultOptions().ChunkBytes
+	}
+	if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+		return nil, fmt.Errorf("invalid merge pattern %q", pattern)
+	}
+	names, err := loadSubredditNames(opts.OutputDir, opts.NameMode, opts.LowercaseSubreddits, defaultPermissions)
+	if err != nil {
+		return nil, fmt.Errorf("error loading subreddit names: %v", err)
+	}
+	dict, err := LoadDictionary(opts.OutputDir)
+	if err != nil {
+		return nil, err
+	}
+	matches := func(name string) bool {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+	if !strings.ContainsAny(pattern, "*?[\\") {
+		file := names.lookup(pattern)
+		matches = func(name string) bool { return name == file }
+	}
+
+	suffix := opts.OutputCompression.compressedName(opts.Format.extension(), opts.Format)
+	report := &MergeReport{}
+	err = filepath.Walk(opts.OutputDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.Base(path), suffix)
+		if !info.IsDir() && strings.HasSuffix(path, suffix) && matches(partSuffix.ReplaceAllString(name, "")) {
+			report.Files = append(report.Files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking output directory %s: %v", opts.OutputDir, err)
+	}
+	if len(report.Files) == 0 {
+		return nil, fmt.Errorf("no output files match %q", pattern)
+	}
+	sort.Strings(report.Files)
+
+	tmpDir := opts.TmpDir
+	if tmpDir == "" {
+		tmpDir = filepath.Dir(output)
+	}
+	runFile, err := os.CreateTemp(tmpDir, "arctic-merge-*.jsonl")
+	if err != nil {
+		return nil, fmt.Errorf("error creating run file in %s: %v", tmpDir, err)
+	}
+	defer os.Remove(runFile.Name())
+	defer runFile.Close()
+
+	starts, err := writeSortedRuns(ctx, runFile, report.Files, opts.OutputCompression, dict, opts.ChunkBytes, &report.Records)
+	if err != nil {
+		return nil, err
+	}
+	info, err := runFile.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("error getting file info for %s: %v", runFile.Name(), err)
+	}
+	runs := make([]io.Reader, len(starts))
+	for i, start := range starts {
+		end := info.Size()
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		runs[i] = contextReader{ctx: ctx, r: io.NewSectionReader(runFile, start, end-start)}
+	}
+
+	if err := writeMerged(output, runs, opts.CompressionLevel, opts.GzipLevel); err != nil {
+		if ctx.Err() != nil {
+			return nil, ErrInterrupted
+		}
+		return nil, err
+	}
+	return report, nil
+}
+
+// writeSortedRuns cuts the lines of the compressed files, compressed with
+// dict if it isn't nil, into runs of about runBytes, sorts every run by
+// created_utc and appends it to w. It returns the offsets the runs start at
+// and counts the lines in records.
+func writeSortedRuns(ctx context.Context, w io.Writer, paths []string, compression Compression, dict []byte, runBytes int64, records *int64) ([]int64, error) {
+	var starts []int64
+	var offset, size int64
+	var lines [][]byte
+	writer := bufio.NewWriter(w)
+	flush := func() error {
+		if len(lines) == 0 {
+			return nil
+		}
+		keys := make([]float64, len(lines))
+		for i, line := range lines {
+			keys[i] = sortKey(line)
+		}
+		order := make([]int, len(lines))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool { return keys[order[i]] < keys[order[j]] })
+
+		starts = append(starts, offset)
+		for _, i := range order {
+			writer.Write(lines[i])
+			writer.WriteByte('\n')
+			offset += int64(len(lines[i])) + 1
+		}
+		lines, size = lines[:0], 0
+		return writer.Flush()
+	}
+
+	for _, path := range paths {
+		decoder, err := openCompressed(path, compression, dict)
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s: %v", path, err)
+		}
+		reader := newLineReader(contextReader{ctx: ctx, r: decoder}, bufferSize, 0)
+		for reader.Scan() {
+			if len(reader.Bytes()) == 0 {
+				continue
+			}
+			lines = append(lines, append([]byte(nil), reader.Bytes()...))
+			size += int64(len(reader.Bytes())) + 1
+			*records++
+			if size >= runBytes {
+				if err := flush(); err != nil {
+					decoder.Close()
+					return nil, fmt.Errorf("error writing sorted run: %v", err)
+				}
+			}
+		}
+		decoder.Close()
+		if ctx.Err() != nil {
+			return nil, ErrInterrupted
+		}
+		if err := reader.Err(); err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", path, err)
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, fmt.Errorf("error writing sorted run: %v", err)
+	}
+	return starts, nil
+}
+
+// writeMerged merges runs into a temporary file renamed to output once it is
+// complete, compressing it according to its extension.
+func writeMerged(output string, runs []io.Reader, level zstd.EncoderLevel, gzLevel int) error {
+	tmpPath := output + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %v", tmpPath, err)
+	}
+	defer os.Remove(tmpPath) // no-op once renamed
+	defer file.Close()
+
+	var encoder io.WriteCloser
+	switch {
+	case strings.HasSuffix(output, ".zst"):
+		if encoder, err = zstd.NewWriter(file, zstd.WithEncoderLevel(level)); err != nil {
+			return fmt.Errorf("error creating zstd encoder: %v", err)
+		}
+	case strings.HasSuffix(output, ".gz"):
+		if encoder, err = gzip.NewWriterLevel(file, gzipLevel(gzLevel)); err != nil {
+			return fmt.Errorf("error creating gzip encoder: %v", err)
+		}
+	}
+
+	var w io.Writer = file
+	if encoder != nil {
+		w = encoder
+	}
+	buffered := bufio.NewWriterSize(w, 1024*1024)
+	if err := mergeRuns(buffered, runs); err != nil {
+		return fmt.Errorf("error merging into %s: %v", tmpPath, err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("error writing to %s: %v", tmpPath, err)
+	}
+	if encoder != nil {
+		if err := encoder.Close(); err != nil {
+			return fmt.Errorf("error finishing %s: %v", tmpPath, err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error closing %s: %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, output); err != nil {
+		return fmt.Errorf("error renaming %s to %s: %v", tmpPath, output, err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package arctic
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics are live counters of a run, updated by all workers and served in
+// the Prometheus text format. Unlike RunStats they can be read at any time.
+type Metrics struct {
+	filesCompleted   atomic.Int64
+	filesFailed      atomic.Int64
+	filesInterrupted atomic.Int64
+	filesSkipped     atomic.Int64
+	rowsRead         atomic.Int64
+	rowsWritten      atomic.Int64
+	parseErrors      atomic.Int64
+	bytesIn          atomic.Int64
+	bytesOut         atomic.Int64
+	activeWorkers    atomic.Int64
+
+	// The throughput gauge is the decompressed bytes read per second since
+	// the previous scrape
+	mu        sync.Mutex
+	lastBytes int64
+	lastTime  time.Time
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{lastTime: time.Now()}
+}
+
+// throughput returns the bytes read per second since the last call.
+func (m *Metrics) throughput() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	bytes := m.bytesIn.Load()
+	var rate float64
+	if dt := now.Sub(m.lastTime); dt > 0 {
+		rate = float64(bytes-m.lastBytes) / dt.Seconds()
+	}
+	m.lastBytes, m.lastTime = bytes, now
+	return rate
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	fmt.Fprintln(w, "# HELP arctic_files_total Input files finished, by result.")
+	fmt.Fprintln(w, "# TYPE arctic_files_total counter")
+	fmt.Fprintf(w, "arctic_files_total{result=\"completed\"} %d\n", m.filesCompleted.Load())
+	fmt.Fprintf(w, "arctic_files_total{result=\"failed\"} %d\n", m.filesFailed.Load())
+	fmt.Fprintf(w, "arctic_files_total{result=\"interrupted\"} %d\n", m.filesInterrupted.Load())
+	fmt.Fprintf(w, "arctic_files_total{result=\"skipped\"} %d\n", m.filesSkipped.Load())
+
+	writeMetric(w, "arctic_rows_read_total", "counter", "Lines read from the inputs.", m.rowsRead.Load())
+	writeMetric(w, "arctic_rows_written_total", "counter", "Posts routed to an output file.", m.rowsWritten.Load())
+	writeMetric(w, "arctic_parse_errors_total", "counter", "Lines that couldn't be parsed.", m.parseErrors.Load())
+	writeMetric(w, "arctic_bytes_in_total", "counter", "Decompressed bytes read from the inputs.", m.bytesIn.Load())
+	writeMetric(w, "arctic_bytes_out_total", "counter", "Uncompressed bytes written to output files.", m.bytesOut.Load())
+	writeMetric(w, "arctic_active_workers", "gauge", "Input files being processed.", m.activeWorkers.Load())
+
+	fmt.Fprintln(w, "# HELP arctic_throughput_bytes_per_second Decompressed bytes read per second since the previous scrape.")
+	fmt.Fprintln(w, "# TYPE arctic_throughput_bytes_per_second gauge")
+	fmt.Fprintf(w, "arctic_throughput_bytes_per_second %g\n", m.throughput())
+}
+
+func writeMetric(w http.ResponseWriter, name, kind, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
@@ -0,0 +1,68 @@
+package arctic
+
+import (
+	"sync"
+	"time"
+)
+
+// MtimeSource is what Options.PreserveMtime sets the modification time of the
+// compressed files to.
+type MtimeSource string
+
+const (
+	MtimeInputFile     MtimeSource = "input-file"      // newest of the dumps the posts came from
+	MtimeMaxCreatedUTC MtimeSource = "max-created-utc" // created_utc of the newest post
+	MtimeNow           MtimeSource = "now"             // the time the file was compressed
+)
+
+// fileTimes holds the modification time due for each uncompressed output
+// file, the newest one noted.
+type fileTimes struct {
+	mu    sync.Mutex
+	times map[string]time.Time
+}
+
+func newFileTimes() *fileTimes {
+	return &fileTimes{times: make(map[string]time.Time)}
+}
+
+func (t *fileTimes) note(path string, mtime time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if mtime.After(t.times[path]) {
+		t.times[path] = mtime
+	}
+}
+
+func (t *fileTimes) get(path string) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	mtime, ok := t.times[path]
+	return mtime, ok
+}
+
+// noteMtime records the time PreserveMtime sets for path, written records
+// whose newest created_utc is newest, from a dump modified at sourceTime.
+func (p *Processor) noteMtime(path string, newest float64, sourceTime time.Time) {
+	switch p.opts.PreserveMtime {
+	case MtimeInputFile:
+		p.mtimes.note(path, sourceTime)
+	case MtimeMaxCreatedUTC:
+		if newest > 0 {
+			p.mtimes.note(path, time.Unix(0, int64(newest*float64(time.Second))))
+		}
+	}
+}
+
+// compressedMtime returns the modification time PreserveMtime sets for the
+// compressed version of the uncompressed output file path. Files written by
+// an earlier run, or without a created_utc, keep the time they're written at.
+func (p *Processor) compressedMtime(path string) (time.Time, bool) {
+	switch p.opts.PreserveMtime {
+	case "":
+		return time.Time{}, false
+	case MtimeNow:
+		return time.Now(), true
+	}
+	return p.mtimes.get(path)
+}
@@ -0,0 +1,177 @@
+package arctic
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const subredditNamesName = "_subreddit_names.json"
+
+// unnamedSubreddit replaces subreddit names that sanitize to nothing.
+const unnamedSubreddit = "_unnamed"
+
+// NameMode selects how subreddit names are turned into file names, see
+// sanitizeSubredditName.
+type NameMode string
+
+const (
+	NamesASCIIOnly   NameMode = "ascii-only"
+	NamesUnicodeSafe NameMode = "unicode-safe"
+)
+
+// subredditNames assigns every subreddit the name of its output files.
+// Names are sanitized for the filesystem, which can map distinct subreddits
+// to the same name; the later one then gets a short hash of its original name
+// appended so their posts never end up in the same file. The mapping from
+// original to on-disk name is kept in the output directory, so later runs
+// keep appending to the same files.
+//
+// With lowercase, all casings of a subreddit share the file of the lowercased
+// name, and the mapping only records the casing seen first.
+type subredditNames struct {
+	mu        sync.RWMutex
+	path      string
+	mode      NameMode
+	lowercase bool
+	perm      permissions
+	Names     map[string]string `json:"names"` // original -> on-disk name
+	taken     map[string]string // on-disk name -> original
+	folded    map[string]string // lowercased -> original, with lowercase
+	dirty     bool
+}
+
+func loadSubredditNames(dir string, mode NameMode, lowercase bool, perm permissions) (*subredditNames, error) {
+	n := &subredditNames{
+		path:      filepath.Join(dir, subredditNamesName),
+		mode:      mode,
+		lowercase: lowercase,
+		perm:      perm,
+		Names:     make(map[string]string),
+		taken:     make(map[string]string),
+		folded:    make(map[string]string),
+	}
+
+	data, err := os.ReadFile(n.path)
+	if os.IsNotExist(err) {
+		return n, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading subreddit names %s: %v", n.path, err)
+	}
+	if err := json.Unmarshal(data, n); err != nil {
+		return nil, fmt.Errorf("error parsing subreddit names %s: %v", n.path, err)
+	}
+	if n.Names == nil {
+		n.Names = make(map[string]string)
+	}
+	originals := make([]string, 0, len(n.Names))
+	for original, name := range n.Names {
+		n.taken[name] = original
+		originals = append(originals, original)
+	}
+	// Mappings written without lowercase can hold several casings, the
+	// first in sort order wins
+	sort.Strings(originals)
+	for _, original := range originals {
+		if _, ok := n.folded[strings.ToLower(original)]; !ok {
+			n.folded[strings.ToLower(original)] = original
+		}
+	}
+	return n, nil
+}
+
+// canonical returns the casing of original the mapping is keyed by.
+func (n *subredditNames) canonical(original string) string {
+	if !n.lowercase {
+		return original
+	}
+	if first, ok := n.folded[strings.ToLower(original)]; ok {
+		return first
+	}
+	return original
+}
+
+// sanitize returns the on-disk name of original before collisions.
+func (n *subredditNames) sanitize(original string) string {
+	if n.lowercase {
+		original = strings.ToLower(original)
+	}
+	return sanitizeSubredditName(original, n.mode)
+}
+
+// get returns the on-disk name of a subreddit, assigning one on first use.
+func (n *subredditNames) get(original string) string {
+	n.mu.RLock()
+	name, ok := n.Names[n.canonical(original)]
+	n.mu.RUnlock()
+	if ok {
+		return name
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if name, ok := n.Names[n.canonical(original)]; ok {
+		return name
+	}
+
+	name = n.sanitize(original)
+	if other, ok := n.taken[name]; ok && other != original {
+		h := fnv.New32a()
+		h.Write([]byte(original))
+		base := fmt.Sprintf("%s_%08x", name, h.Sum32())
+		name = base
+		for i := 2; n.taken[name] != ""; i++ {
+			name = fmt.Sprintf("%s_%d", base, i)
+		}
+	}
+	n.Names[original] = name
+	n.taken[name] = original
+	n.folded[strings.ToLower(original)] = original
+	n.dirty = true
+	return name
+}
+
+// lookup returns the on-disk name of a subreddit the mapping knows, or the
+// name get would assign to it if there were no collisions.
+func (n *subredditNames) lookup(original string) string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if name, ok := n.Names[n.canonical(original)]; ok {
+		return name
+	}
+	return n.sanitize(original)
+}
+
+// save writes the mapping if it changed, using a temporary file and a rename
+// like the progress manifest.
+func (n *subredditNames) save() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(n, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding subreddit names: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(n.path), n.perm.dir); err != nil {
+		return fmt.Errorf("error creating directory %s: %v", filepath.Dir(n.path), err)
+	}
+
+	tmpPath := n.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, n.perm.file); err != nil {
+		return fmt.Errorf("error writing subreddit names %s: %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, n.path); err != nil {
+		return fmt.Errorf("error replacing subreddit names %s: %v", n.path, err)
+	}
+	n.dirty = false
+	return nil
+}
@@ -0,0 +1,109 @@
+package arctic
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeSubredditName(t *testing.T) {
+	tests := []struct {
+		name string
+		mode NameMode
+		want string
+	}{
+		{"golang", NamesASCIIOnly, "golang"},
+		{"a.b/c", NamesASCIIOnly, "abc"},
+		{"", NamesASCIIOnly, unnamedSubreddit},
+		{"日本語", NamesASCIIOnly, unnamedSubreddit},
+		{"../..", NamesASCIIOnly, unnamedSubreddit},
+		{"", NamesUnicodeSafe, unnamedSubreddit},
+		{"日本", NamesUnicodeSafe, "%E6%97%A5%E6%9C%AC"},
+		{"50%", NamesUnicodeSafe, "50%25"},
+		{strings.Repeat("x", 60), NamesASCIIOnly, strings.Repeat("x", 50)},
+	}
+	for _, tt := range tests {
+		if got := sanitizeSubredditName(tt.name, tt.mode); got != tt.want {
+			t.Errorf("sanitizeSubredditName(%q, %s) = %q, want %q", tt.name, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeSubredditNameUnicodeSafeIsReversible(t *testing.T) {
+	for _, name := range []string{"日本語", "a b", "100%", "Ünïcode", "../.."} {
+		sanitized := sanitizeSubredditName(name, NamesUnicodeSafe)
+		if strings.ContainsAny(sanitized, `/\. `) {
+			t.Errorf("%q sanitizes to %q, which isn't a safe file name", name, sanitized)
+		}
+		if original, err := url.PathUnescape(sanitized); err != nil || original != name {
+			t.Errorf("%q sanitizes to %q, which decodes to %q, %v", name, sanitized, original, err)
+		}
+	}
+}
+
+func TestSubredditNamesEmptyNamesDontShareFiles(t *testing.T) {
+	names, err := loadSubredditNames(t.TempDir(), NamesASCIIOnly, false, defaultPermissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := names.get("日本"), names.get("中文")
+	if first != unnamedSubreddit {
+		t.Errorf("the first name without ASCII characters got %q, want %q", first, unnamedSubreddit)
+	}
+	if second == first || !strings.HasPrefix(second, unnamedSubreddit+"_") {
+		t.Errorf("the second name without ASCII characters got %q, want a suffixed %q", second, unnamedSubreddit)
+	}
+	if again := names.get("日本"); again != first {
+		t.Errorf("the first name got %q on its second use, want %q", again, first)
+	}
+}
+
+func TestProcessFileLowercaseSubreddits(t *testing.T) {
+	opts := DefaultOptions()
+	opts.OutputCompression = CompressionNone
+	opts.LowercaseSubreddits = true
+	p := newTestProcessor(t, opts)
+	path := writeDump(t, p.opts.InputDir, "RS_2023-01.zst",
+		post("AskReddit", "a", 1672531200),
+		post("askreddit", "b", 1672531201),
+		post("ASKREDDIT", "c", 1672531202),
+		post("golang", "d", 1672531203),
+	)
+	if err := p.ProcessFile(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.names.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(p.opts.OutputDir, "2023-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, entry := range entries {
+		files = append(files, entry.Name())
+	}
+	if want := []string{"askreddit.jsonl", "golang.jsonl"}; !slices.Equal(files, want) {
+		t.Errorf("got files %v, want %v", files, want)
+	}
+	if lines := readLines(t, filepath.Join(p.opts.OutputDir, "2023-01", "askreddit.jsonl")); len(lines) != 3 {
+		t.Errorf("got %d posts for every casing of askreddit, want 3", len(lines))
+	}
+
+	// The mapping keeps the casing seen first
+	names, err := loadSubredditNames(p.opts.OutputDir, NamesASCIIOnly, true, defaultPermissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := names.Names["AskReddit"]; !ok || name != "askreddit" || len(names.Names) != 2 {
+		t.Errorf("got mapping %v, want AskReddit and golang", names.Names)
+	}
+	if name := names.get("askREDDIT"); name != "askreddit" {
+		t.Errorf("another casing got %q after reloading the mapping, want askreddit", name)
+	}
+}
@@ -0,0 +1,103 @@
+package arctic
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// Partition selects the directories the output is split into below the
+// output directory.
+type Partition string
+
+const (
+	// PartitionFile takes the month from the dump's filename.
+	PartitionFile Partition = "file"
+
+	// The others bucket every post by its created_utc, in UTC, so a single
+	// dump can fan out into several directories.
+	PartitionYear  Partition = "year"
+	PartitionMonth Partition = "month"
+	PartitionDay   Partition = "day"
+)
+
+// PartitionKey selects the field of a post that names its output file.
+type PartitionKey string
+
+const (
+	PartitionBySubreddit PartitionKey = "subreddit"
+	PartitionByAuthor    PartitionKey = "author"
+	PartitionByDomain    PartitionKey = "domain"
+)
+
+// singleFile is the name of the output file of every post with
+// Options.SingleFile.
+const singleFile = "_all"
+
+// noSubreddit is the output file of the posts without a subreddit, so they
+// never share a file named ".jsonl". Subreddits whose name sanitizes to
+// nothing are still subreddits and get an "_unnamed" file each.
+const noSubreddit = "_no_subreddit"
+
+// missingSubreddit reports whether subreddit is missing, empty or blank.
+func missingSubreddit(subreddit string) bool {
+	return strings.TrimSpace(subreddit) == ""
+}
+
+// deletedAuthors is the output file of the posts of deleted or removed
+// accounts when partitioning by author.
+const deletedAuthors = "_deleted"
+
+// isDeletedAuthor reports whether author stands for a deleted or removed
+// account rather than a user.
+func isDeletedAuthor(author string) bool {
+	return author == "" || author == "[deleted]" || author == "[removed]"
+}
+
+// fileNameOf returns the name of the output file of record, without
+// extension, registering new names.
+func (p *Processor) fileNameOf(record Record) string {
+	if p.opts.SingleFile {
+		return singleFile
+	}
+	switch p.opts.PartitionKey {
+	case PartitionByAuthor:
+		if isDeletedAuthor(record.authorName()) {
+			return deletedAuthors
+		}
+		return p.names.get(record.authorName())
+	case PartitionByDomain:
+		return p.names.get(record.domainName())
+	}
+	if missingSubreddit(record.subredditName()) {
+		return noSubreddit
+	}
+	return p.names.get(record.subredditName())
+}
+
+var partitionLayouts = map[Partition]string{
+	PartitionYear:  "2006",
+	PartitionMonth: "2006-01",
+	PartitionDay:   "2006-01-02",
+}
+
+// partitionOf returns the directory below the output directory of a record
+// from a dump of monthYear. Undated posts stay in monthYear, FlatBySubreddit
+// drops the month and SplitByType puts it below the record's type, which
+// OutputTemplate always does. Otherwise comments go to a "comments"
+// directory below it, so they never share a file with submissions.
+func (p *Processor) partitionOf(record Record, monthYear string) string {
+	partition := monthYear
+	if layout, ok := partitionLayouts[p.opts.Partition]; ok && record.createdUTC() > 0 {
+		partition = time.Unix(int64(record.createdUTC()), 0).UTC().Format(layout)
+	}
+	if p.opts.FlatBySubreddit {
+		partition = ""
+	}
+	if p.opts.SplitByType || p.template != nil {
+		partition = path.Join(string(recordType(record)), partition)
+	} else if recordType(record) == DumpComments {
+		partition = path.Join(partition, string(DumpComments))
+	}
+	return partition
+}
@@ -0,0 +1,120 @@
+package arctic
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// comment returns a comment in subreddit with id, created at created.
+func comment(subreddit, id string, created int64) string {
+	return fmt.Sprintf(`{"id":%q,"subreddit":%q,"created_utc":%d,"body":"b","link_id":"t3_x","parent_id":"t3_x"}`, id, subreddit, created)
+}
+
+func TestProcessFileMixedRecordTypes(t *testing.T) {
+	lines := []string{
+		post("golang", "p1", 1672531200),
+		comment("golang", "c1", 1672531201),
+		comment("rust", "c2", 1672531202),
+		post("golang", "p2", 1672531203),
+	}
+	tests := []struct {
+		name        string
+		splitByType bool
+		fastDecode  bool
+		files       map[string]int // path -> records
+	}{
+		{"per month", false, false, map[string]int{
+			"2023-01/golang.jsonl":          2,
+			"2023-01/comments/golang.jsonl": 1,
+			"2023-01/comments/rust.jsonl":   1,
+		}},
+		{"fast decode", false, true, map[string]int{
+			"2023-01/golang.jsonl":          2,
+			"2023-01/comments/golang.jsonl": 1,
+			"2023-01/comments/rust.jsonl":   1,
+		}},
+		{"split by type", true, false, map[string]int{
+			"submissions/2023-01/golang.jsonl": 2,
+			"comments/2023-01/golang.jsonl":    1,
+			"comments/2023-01/rust.jsonl":      1,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.OutputCompression = CompressionNone
+			opts.DetectRecordType = true
+			opts.SplitByType = tt.splitByType
+			opts.FastDecode = tt.fastDecode
+			p := newTestProcessor(t, opts)
+			path := writeDump(t, p.opts.InputDir, "RS_2023-01.zst", lines...)
+			if err := p.ProcessFile(context.Background(), path); err != nil {
+				t.Fatal(err)
+			}
+			for file, want := range tt.files {
+				if got := readLines(t, filepath.Join(p.opts.OutputDir, filepath.FromSlash(file))); len(got) != want {
+					t.Errorf("got %d records in %s, want %d", len(got), file, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessFileNoSubreddit(t *testing.T) {
+	opts := DefaultOptions()
+	opts.OutputCompression = CompressionNone
+	p := newTestProcessor(t, opts)
+	path := writeDump(t, p.opts.InputDir, "RS_2023-01.zst",
+		`{"id":"a","created_utc":1672531200}`,
+		post("", "b", 1672531201),
+		post("???", "c", 1672531202),
+		post("日本", "d", 1672531203),
+		post("golang", "e", 1672531204),
+	)
+	if err := p.ProcessFile(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(p.opts.OutputDir, "2023-01")
+	if got := readLines(t, filepath.Join(dir, noSubreddit+".jsonl")); len(got) != 2 {
+		t.Errorf("got %d records in %s.jsonl, want 2", len(got), noSubreddit)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Subreddits that sanitize to nothing keep a file each
+	var unnamed int
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".jsonl")
+		if name == "" {
+			t.Errorf("created %s", entry.Name())
+		}
+		if !strings.HasPrefix(name, unnamedSubreddit) {
+			continue
+		}
+		unnamed++
+		if got := readLines(t, filepath.Join(dir, entry.Name())); len(got) != 1 {
+			t.Errorf("got %d records in %s, want 1", len(got), entry.Name())
+		}
+	}
+	if unnamed != 2 {
+		t.Errorf("got %d %s files, want 2", unnamed, unnamedSubreddit)
+	}
+	if got := p.Stats().Files[filepath.Base(path)].NoSubreddit; got != 2 {
+		t.Errorf("got NoSubreddit %d, want 2", got)
+	}
+
+	report, err := VerifyOutput(context.Background(), p.opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Files != 4 || report.Records != 5 || report.Failures != 0 {
+		t.Errorf("verified %d files with %d records and %d failures, want 4, 5 and none: %v",
+			report.Files, report.Records, report.Failures, report.Violations)
+	}
+}
@@ -0,0 +1,75 @@
+package arctic
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// partSuffix ends the names of the output files after the first of a
+// subreddit rotated by MaxFileBytes, e.g. "askreddit.part0001.jsonl".
+var partSuffix = regexp.MustCompile(`\.part\d{4,}$`)
+
+// partName returns the name of part n of an output file named name, without
+// extension. Part 0 keeps the name.
+func partName(name string, n int) string {
+	if n == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s.part%04d", name, n)
+}
+
+// partPath returns the path of part n of the uncompressed output file at
+// base.
+func (f OutputFormat) partPath(base string, n int) string {
+	return partName(strings.TrimSuffix(base, f.extension()), n) + f.extension()
+}
+
+// partBase returns the path of the first part of the uncompressed output
+// file at path.
+func (f OutputFormat) partBase(path string) string {
+	return partSuffix.ReplaceAllString(strings.TrimSuffix(path, f.extension()), "") + f.extension()
+}
+
+// partCounter tracks which part of every rotated output file is written to,
+// shared by all workers.
+type partCounter struct {
+	mu     sync.Mutex
+	format OutputFormat
+	parts  map[string]int // base path -> current part
+}
+
+func newPartCounter(format OutputFormat) *partCounter {
+	return &partCounter{format: format, parts: make(map[string]int)}
+}
+
+// current returns the part of base being written to. The first time a base
+// is seen, parts an earlier, interrupted run left behind are picked up again.
+func (c *partCounter) current(base string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n, ok := c.parts[base]; ok {
+		return n
+	}
+	n := 0
+	for {
+		if _, err := os.Stat(c.format.partPath(base, n+1)); err != nil {
+			break
+		}
+		n++
+	}
+	c.parts[base] = n
+	return n
+}
+
+// advance moves base on from the full part n, unless another worker already
+// did.
+func (c *partCounter) advance(base string, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.parts[base] == n {
+		c.parts[base] = n + 1
+	}
+}
@@ -0,0 +1,63 @@
+package arctic
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// pausePollInterval is how often the pause file is checked for.
+const pausePollInterval = time.Second
+
+// pauseControl pauses the workers while a control file exists, see
+// Options.PauseFile. It is checked lazily, at most every pausePollInterval.
+type pauseControl struct {
+	path    string
+	log     *slog.Logger
+	checked atomic.Int64 // unix nanoseconds of the last check
+	paused  atomic.Bool
+}
+
+func newPauseControl(path string, log *slog.Logger) *pauseControl {
+	return &pauseControl{path: path, log: log}
+}
+
+// isPaused reports whether the pause file exists.
+func (c *pauseControl) isPaused() bool {
+	now := time.Now().UnixNano()
+	last := c.checked.Load()
+	if now-last < int64(pausePollInterval) || !c.checked.CompareAndSwap(last, now) {
+		return c.paused.Load()
+	}
+	_, err := os.Stat(c.path)
+	paused := err == nil
+	if c.paused.Swap(paused) != paused {
+		if paused {
+			c.log.Info("pausing, remove the pause file to resume", "path", c.path)
+		} else {
+			c.log.Info("resuming")
+		}
+	}
+	return paused
+}
+
+// wait blocks while the pause file exists or until ctx is cancelled. It
+// returns how long it waited.
+func (c *pauseControl) wait(ctx context.Context) time.Duration {
+	if !c.isPaused() {
+		return 0
+	}
+	start := time.Now()
+	ticker := time.NewTicker(pausePollInterval)
+	defer ticker.Stop()
+	for c.isPaused() {
+		select {
+		case <-ctx.Done():
+			return time.Since(start)
+		case <-ticker.C:
+		}
+	}
+	return time.Since(start)
+}
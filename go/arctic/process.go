@@ -0,0 +1,974 @@
+package arctic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Dump kinds, identified by the filename prefix
+type dumpKind int
+
+const (
+	submissionDump dumpKind = iota
+	commentDump
+
+	// mixedDump interleaves submissions and comments, see
+	// Options.DetectRecordType.
+	mixedDump
+)
+
+var dumpPrefixes = map[dumpKind]string{
+	submissionDump: "RS_",
+	commentDump:    "RC_",
+}
+
+// DumpType names a kind of dump, and is the output directory of its posts
+// with Options.SplitByType.
+type DumpType string
+
+const (
+	DumpSubmissions DumpType = "submissions"
+	DumpComments    DumpType = "comments"
+)
+
+func (k dumpKind) dumpType() DumpType {
+	if k == commentDump {
+		return DumpComments
+	}
+	return DumpSubmissions
+}
+
+// detectKind tells the records of a mixedDump apart: comments have a body
+// and no title, everything else is taken to be a submission.
+func detectKind(body, title bool) dumpKind {
+	if body && !title {
+		return commentDump
+	}
+	return submissionDump
+}
+
+// recordType returns the type of the dump a record comes from.
+func recordType(record Record) DumpType {
+	if _, ok := record.(RedditComment); ok {
+		return DumpComments
+	}
+	return DumpSubmissions
+}
+
+// Structs
+
+// chunkKey identifies the output file buffered posts go to.
+type chunkKey struct {
+	partition string // directory below the output directory, e.g. "2023-01"
+	subreddit string
+}
+
+// Record is a single decoded line of a dump, either a submission or a comment.
+// The typed fields are only used for routing; the original line is kept so
+// the output contains every field of the input.
+type Record interface {
+	id() string
+	subredditName() string
+	authorName() string
+	domainName() string // empty for comments
+	createdUTC() float64
+	rawJSON() json.RawMessage
+	withRaw(raw json.RawMessage) Record
+}
+
+type RedditPost struct {
+	ID         string  `json:"id"`
+	Subreddit  string  `json:"subreddit"`
+	Author     string  `json:"author"`
+	Domain     string  `json:"domain"`
+	CreatedUTC float64 `json:"created_utc"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+type RedditComment struct {
+	ID         string  `json:"id"`
+	Subreddit  string  `json:"subreddit"`
+	Author     string  `json:"author"`
+	CreatedUTC float64 `json:"created_utc"`
+	Body       string  `json:"body"`
+	LinkID     string  `json:"link_id"`
+	ParentID   string  `json:"parent_id"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+// flexibleFloat decodes a JSON number or a string holding one, as some dumps
+// quote created_utc. null decodes to 0. Strings like "NaN" or "Inf" are
+// rejected, as the time ranges and sorting need finite times.
+type flexibleFloat float64
+
+func (f *flexibleFloat) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		data = []byte(s)
+	}
+	v, err := strconv.ParseFloat(string(data), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("invalid number %s", data)
+	}
+	*f = flexibleFloat(v)
+	return nil
+}
+
+// UnmarshalJSON accepts created_utc as a number or a string.
+func (p *RedditPost) UnmarshalJSON(data []byte) error {
+	type plain RedditPost
+	aux := struct {
+		*plain
+		CreatedUTC flexibleFloat `json:"created_utc"`
+	}{plain: (*plain)(p)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	p.CreatedUTC = float64(aux.CreatedUTC)
+	return nil
+}
+
+// UnmarshalJSON accepts created_utc as a number or a string.
+func (c *RedditComment) UnmarshalJSON(data []byte) error {
+	type plain RedditComment
+	aux := struct {
+		*plain
+		CreatedUTC flexibleFloat `json:"created_utc"`
+	}{plain: (*plain)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	c.CreatedUTC = float64(aux.CreatedUTC)
+	return nil
+}
+
+func (p RedditPost) id() string    { return p.ID }
+func (c RedditComment) id() string { return c.ID }
+
+func (p RedditPost) subredditName() string    { return p.Subreddit }
+func (c RedditComment) subredditName() string { return c.Subreddit }
+
+func (p RedditPost) authorName() string    { return p.Author }
+func (c RedditComment) authorName() string { return c.Author }
+
+func (p RedditPost) domainName() string    { return p.Domain }
+func (c RedditComment) domainName() string { return "" }
+
+func (p RedditPost) createdUTC() float64    { return p.CreatedUTC }
+func (c RedditComment) createdUTC() float64 { return c.CreatedUTC }
+
+func (p RedditPost) rawJSON() json.RawMessage    { return p.Raw }
+func (c RedditComment) rawJSON() json.RawMessage { return c.Raw }
+
+func (p RedditPost) withRaw(raw json.RawMessage) Record    { p.Raw = raw; return p }
+func (c RedditComment) withRaw(raw json.RawMessage) Record { c.Raw = raw; return c }
+
+// File processing functions
+
+// ProcessFile splits a single dump into per-subreddit JSONL files below the
+// output directory. It returns ErrAlreadyProcessed for files the manifest
+// lists as completed, or in Incremental mode whose month already exists, and
+// ErrInterrupted when ctx was cancelled midway. Files that timed out with
+// FlushOnTimeout are recorded as partial. Files read with a Limit or Sample
+// are not recorded in the manifest.
+func (p *Processor) ProcessFile(ctx context.Context, path string) (err error) {
+	var size int64
+	if p.total != nil {
+		defer func() { p.total.finish(size, err) }()
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error getting file info for %s: %v", path, err)
+	}
+	size = info.Size()
+	if !p.opts.Force && !p.opts.DryRun && p.manifest.isCompleted(path, info.Size()) {
+		return ErrAlreadyProcessed
+	}
+
+	kind, monthYear := parseDumpFilename(filepath.Base(path), p.opts.NamePattern)
+	if p.opts.DetectRecordType {
+		kind = mixedDump
+	}
+	if !p.opts.MonthPattern.MatchString(monthYear) {
+		p.log.Warn("no valid month in the dump's name, writing its output to "+unknownMonth, "path", path, "month", monthYear)
+		monthYear = unknownMonth
+	}
+	// The output directory of the month, as recorded in the manifest
+	month := monthYear
+	if p.opts.SplitByType && kind != mixedDump {
+		month = string(kind.dumpType()) + "/" + monthYear
+	}
+	if p.opts.Incremental && !p.opts.DryRun {
+		if err := p.claimMonth(path, info.Size(), month); err != nil {
+			return err
+		}
+	}
+
+	p.log.Info("processing file", "path", path)
+
+	var file *os.File
+	err = p.retry.do("open "+path, func() (err error) {
+		file, err = os.Open(path)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error opening file %s: %v", path, err)
+	}
+	defer file.Close()
+
+	// The dump is checksummed as it is decompressed. Dumps made of several
+	// concatenated frames are read to the end of the last one.
+	checksum := newChecksum()
+	frames := newFrameCounter()
+	compressed := &countingReader{r: io.TeeReader(file, io.MultiWriter(checksum, frames))}
+	zReader, err := zstd.NewReader(compressed, dumpDecoderOptions(p.opts)...)
+	if err != nil {
+		return fmt.Errorf("error creating zstd reader for file %s: %v", path, err)
+	}
+	defer zReader.Close()
+
+	progressLog := newProgressLog(filepath.Base(path), info.Size(), &compressed.n)
+	var readErr error
+	if err := p.processStream(ctx, path, kind, monthYear, info.ModTime(), zReader, progressLog, &readErr); err != nil {
+		// Errors reading the decompressed stream that aren't errors reading
+		// the file come from the decoder
+		if errors.Is(readErr, zstd.ErrWindowSizeExceeded) {
+			// Not corrupt, the decoder just isn't allowed to use the window
+			return fmt.Errorf("error reading %s: %v, raise the decoder max window or max memory", path, readErr)
+		}
+		if readErr != nil && compressed.readErr() == nil {
+			return fmt.Errorf("%w: %s: %v", ErrCorruptInput, path, readErr)
+		}
+		if errors.Is(err, ErrTimeout) && p.opts.FlushOnTimeout && !p.opts.DryRun && p.opts.Limit == 0 && p.opts.Sample <= 1 {
+			if err := p.manifest.markPartial(path, info.Size(), month); err != nil {
+				p.log.Error("error updating progress manifest", "path", path, "err", err)
+			}
+		}
+		return err
+	}
+	// Limited and sampled runs only see part of the file
+	if !p.opts.DryRun && p.opts.Limit == 0 && p.opts.Sample <= 1 {
+		// Anything after the end of the stream still counts for the checksum
+		if _, err := io.Copy(io.Discard, compressed); err != nil {
+			return fmt.Errorf("error reading file %s: %v", path, err)
+		}
+		if err := p.manifest.markCompleted(path, info.Size(), month, checksumString(checksum)); err != nil {
+			return fmt.Errorf("error updating progress manifest: %v", err)
+		}
+	}
+	if frames.err != nil {
+		p.log.Warn("error counting zstd frames", "path", path, "err", frames.err)
+	} else {
+		p.log.Debug("read zstd frames", "path", path, "frames", frames.frames)
+	}
+
+	return nil
+}
+
+// dumpDecoderOptions returns the options of the zstd decoders of dumps.
+func dumpDecoderOptions(opts Options) []zstd.DOption {
+	var dopts []zstd.DOption
+	if opts.DecoderConcurrency > 0 {
+		dopts = append(dopts, zstd.WithDecoderConcurrency(opts.DecoderConcurrency))
+	}
+	if opts.DecoderMaxWindow > 0 {
+		dopts = append(dopts, zstd.WithDecoderMaxWindow(opts.DecoderMaxWindow))
+	}
+	if opts.DecoderMaxMemory > 0 {
+		dopts = append(dopts, zstd.WithDecoderMaxMemory(opts.DecoderMaxMemory))
+	}
+	return dopts
+}
+
+// ProcessReader splits an uncompressed JSONL stream of submissions, such as
+// stdin, like ProcessFile does with a dump. There is no filename to take the
+// month from, so it is passed in; name identifies the stream in logs and
+// stats. It returns ErrInterrupted when ctx was cancelled midway.
+func (p *Processor) ProcessReader(ctx context.Context, r io.Reader, name, monthYear string) (err error) {
+	if p.total != nil {
+		defer func() { p.total.finish(0, err) }()
+	}
+	p.log.Info("processing stream", "name", name, "month", monthYear)
+
+	counted := &countingReader{r: r}
+	progressLog := newProgressLog(name, 0, &counted.n)
+	kind := submissionDump
+	if p.opts.DetectRecordType {
+		kind = mixedDump
+	}
+	return p.processStream(ctx, name, kind, monthYear, time.Now(), counted, progressLog, nil)
+}
+
+// processStream does the actual splitting for ProcessFile and ProcessReader,
+// reading decompressed lines from r, last modified at modTime. If reading r
+// fails and readErr isn't nil, the read error is stored in it.
+func (p *Processor) processStream(ctx context.Context, name string, kind dumpKind, monthYear string, modTime time.Time, r io.Reader, progressLog *FileProgressLog, readErr *error) error {
+	fs := &FileStats{Started: time.Now()}
+	if p.cpu != nil {
+		p.cpu.start(fs)
+	}
+	defer func() {
+		if p.cpu != nil {
+			p.cpu.stop(fs)
+		}
+		fs.Finished = time.Now()
+		p.stats.addFile(filepath.Base(name), fs)
+	}()
+
+	if limited := p.readLimits(); len(limited) > 0 {
+		r = limitedReader{ctx: ctx, r: r, buckets: limited}
+	}
+	lines, err := newRecordReader(r, bufferSize, p.opts.MaxLineSize, p.opts.RecordDelimiter)
+	if err != nil {
+		return err
+	}
+
+	chunk := make(map[chunkKey][]Record)
+	rowCount := 0
+	var chunkBytes, memoryBytes int64
+
+	// CountOnly tallies the lines right away, chunk stays empty
+	var counts map[string]*SubredditStats
+	if p.opts.CountOnly {
+		counts = make(map[string]*SubredditStats)
+		defer func() {
+			for subreddit, ss := range counts {
+				p.stats.addSubreddit(subreddit, ss.RowsWritten, ss.BytesOut)
+			}
+		}()
+	}
+
+	var seenIDs *idWindow
+	if p.opts.Dedupe {
+		seenIDs = newIDWindow(p.opts.DedupeWindow)
+	}
+
+	// Whatever was written, even before an error, has to stay findable
+	// under the same names
+	if !p.opts.DryRun {
+		defer func() {
+			if err := p.names.save(); err != nil {
+				p.log.Error("error saving subreddit names", "err", err)
+			}
+			if err := p.index.save(); err != nil {
+				p.log.Error("error saving index", "err", err)
+			}
+		}()
+	}
+
+	// Closed explicitly once the last chunk is written, so flush errors fail
+	// the file; the deferred call only cleans up after other errors.
+	writers := newWriterCache(p.opts.MaxOpenFiles, p.retry, p.perm())
+	writers.touched = p.touched
+	writers.sourceTime = modTime
+	writers.sorted = p.sorted
+	if p.opts.Incremental && !p.opts.DryRun {
+		writers.seen = make(map[string]struct{})
+	}
+	defer func() {
+		if err := writers.Close(); err != nil {
+			p.log.Error("error closing output files", "path", name, "err", err)
+		}
+		// Also after an error, as the last worker writing to a file has to
+		// merge the runs of all of them
+		if err := p.mergeSorted(writers); err != nil {
+			p.log.Error("error sorting output files", "path", name, "err", err)
+		}
+	}()
+
+	// Dry runs only tally the chunks, there's nothing to spill
+	var spill *chunkSpill
+	if p.opts.TmpDir != "" && !p.opts.DryRun {
+		spill = newChunkSpill(p.opts.TmpDir)
+		defer func() {
+			if err := spill.close(); err != nil {
+				p.log.Error("error removing spill file", "path", name, "err", err)
+			}
+		}()
+	}
+
+	progressLog.lineLimit = p.opts.Limit
+	progressLog.hidden = !p.opts.FileProgress
+	progressLog.estimateRows = p.opts.RowEstimate
+	progressLog.readLimit = p.opts.MaxReadMBps
+	if p.opts.MaxTotalReadMBps > 0 && (progressLog.readLimit == 0 || p.opts.MaxTotalReadMBps < progressLog.readLimit) {
+		progressLog.readLimit = p.opts.MaxTotalReadMBps
+	}
+	progressLog.finalOnly = p.total != nil || p.opts.Quiet
+	progressLog.updateInterval = p.opts.ProgressInterval
+	if p.total != nil {
+		p.total.add(progressLog)
+		defer p.total.remove(progressLog)
+	}
+
+	badLines := newBadLineLog(p.opts.OutputDir, name, p.opts.DryRun, p.perm())
+	defer func() {
+		if err := badLines.Close(); err != nil {
+			p.log.Error("error closing bad line log", "path", badLines.path, "err", err)
+		}
+		if badLines.count > 0 && p.opts.DryRun {
+			p.log.Warn("unparseable lines", "path", name, "count", badLines.count)
+		} else if badLines.count > 0 {
+			p.log.Warn("unparseable lines", "path", name, "count", badLines.count, "written_to", badLines.path)
+		}
+	}()
+
+	scanner := p.newLineScanner(lines, kind, monthYear)
+	defer scanner.close()
+
+	// In Incremental mode the files of a dump are recorded once they were
+	// written to, so restarting its month can remove just those
+	recordOutputs := func() {
+		if len(writers.opened) == 0 {
+			return
+		}
+		if err := p.manifest.addFiles(name, p.opts.OutputDir, writers.opened); err != nil {
+			p.log.Error("error updating progress manifest", "path", name, "err", err)
+		}
+		writers.opened = writers.opened[:0]
+	}
+
+	var deadline time.Time
+	if p.opts.Timeout > 0 {
+		deadline = time.Now().Add(p.opts.Timeout)
+	}
+	timedOut := false
+	parsedAny := false // whether a line was decoded, for NotJSONLLines
+	for {
+		// Checked for every line, as filters may skip nearly all of them
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			if p.opts.FlushOnTimeout {
+				timedOut = true
+				break
+			}
+			progressLog.LogProgress("\n")
+			return fmt.Errorf("%w of %s reached after %d rows", ErrTimeout, p.opts.Timeout, progressLog.i)
+		}
+
+		sl, ok := scanner.next()
+		if !ok || ctx.Err() != nil {
+			break
+		}
+
+		progressLog.OnLine(sl.size)
+		recordBytes, outcome := p.bufferLine(sl, monthYear, seenIDs, fs, chunk, counts)
+		switch outcome {
+		case lineBlank:
+			continue
+		case lineSkipped:
+			parsedAny = parsedAny || !sl.sampledOut
+			progressLog.OnSkippedRow()
+			continue
+		case lineUnparseable:
+			if err := badLines.record(sl.number, sl.raw, sl.err); err != nil {
+				return fmt.Errorf("error recording unparseable line: %v", err)
+			}
+			if p.report != nil {
+				p.report.addLineError(name, sl.number, sl.err)
+			}
+			if p.opts.MaxErrors > 0 && fs.ParseErrors >= p.opts.MaxErrors {
+				progressLog.LogProgress("\n")
+				return fmt.Errorf("aborting after %d unparseable lines", fs.ParseErrors)
+			}
+			if !parsedAny && p.opts.NotJSONLLines > 0 && fs.ParseErrors >= p.opts.NotJSONLLines {
+				progressLog.LogProgress("\n")
+				return fmt.Errorf("%w: the first %d lines of %s aren't JSON objects, last error: %v", ErrNotJSONL, fs.ParseErrors, name, sl.err)
+			}
+			continue
+		}
+		parsedAny = true
+
+		rowCount++
+		chunkBytes += recordBytes
+		memoryBytes += recordBytes
+		progressLog.OnRow()
+
+		if rowCount >= chunkSize || (p.opts.ChunkBytes > 0 && chunkBytes >= p.opts.ChunkBytes) {
+			err := p.flushChunk(ctx, writers, chunk, spill)
+			recordOutputs()
+			if errors.Is(err, ErrInterrupted) {
+				// The rest of the chunk is flushed below
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("error writing chunk to disk: %v", err)
+			}
+			chunk = make(map[chunkKey][]Record)
+			rowCount = 0
+			chunkBytes = 0
+			memoryBytes = 0
+
+			if p.pause != nil && p.pause.isPaused() {
+				progressLog.paused = true
+				progressLog.LogProgress("")
+				paused := p.pause.wait(ctx)
+				if !deadline.IsZero() {
+					deadline = deadline.Add(paused) // not part of the timeout
+				}
+				progressLog.paused = false
+			}
+		} else if spill != nil && memoryBytes >= p.opts.SpillBytes {
+			if err := spill.write(chunk); err != nil {
+				return err
+			}
+			chunk = make(map[chunkKey][]Record)
+			memoryBytes = 0
+		}
+	}
+
+	// What was read is flushed even after a cancel, see ErrInterrupted, or
+	// a timeout with FlushOnTimeout
+	if len(chunk) > 0 || (spill != nil && !spill.empty()) {
+		err := p.flushChunk(context.WithoutCancel(ctx), writers, chunk, spill)
+		recordOutputs()
+		if err != nil {
+			return fmt.Errorf("error writing final chunk to disk: %v", err)
+		}
+	}
+	if err := writers.Close(); err != nil {
+		return fmt.Errorf("error closing output files: %v", err)
+	}
+	if err := p.mergeSorted(writers); err != nil {
+		return fmt.Errorf("error sorting output file: %v", err)
+	}
+
+	progressLog.LogProgress("\n")
+
+	scanner.close()
+	if err := lines.Err(); err != nil {
+		if readErr != nil {
+			*readErr = err
+		}
+		return fmt.Errorf("error reading %s: %v", name, err)
+	}
+
+	if timedOut {
+		return fmt.Errorf("%w of %s reached after %d rows, kept the posts read so far", ErrTimeout, p.opts.Timeout, progressLog.i)
+	}
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
+	return nil
+}
+
+// lineOutcome is what bufferLine did with a scanned line.
+type lineOutcome int
+
+const (
+	lineBuffered lineOutcome = iota
+	lineBlank
+	lineSkipped     // sampled out, filtered, deleted, dropped or a duplicate
+	lineUnparseable // sl.err says why
+)
+
+// bufferLine is the per-line hot path after decoding: it filters a line,
+// routes it to its subreddit and partition and appends it to chunk, counting
+// it in fs. With counts, the line is only counted there instead of buffered.
+// It returns the bytes the line added to chunk.
+func (p *Processor) bufferLine(sl scannedLine, monthYear string, seenIDs *idWindow, fs *FileStats, chunk map[chunkKey][]Record, counts map[string]*SubredditStats) (int64, lineOutcome) {
+	fs.BytesIn += int64(sl.size)
+	p.metrics.bytesIn.Add(int64(sl.size))
+	if sl.blank {
+		return 0, lineBlank
+	}
+	fs.RowsRead++
+	p.metrics.rowsRead.Add(1)
+
+	if sl.sampledOut || sl.filtered {
+		fs.RowsFiltered++
+		return 0, lineSkipped
+	}
+	if sl.err != nil {
+		fs.ParseErrors++
+		p.metrics.parseErrors.Add(1)
+		return 0, lineUnparseable
+	}
+	if sl.dropped {
+		fs.RowsDropped++
+		return 0, lineSkipped
+	}
+	if sl.deleted {
+		fs.RowsDeleted++
+		return 0, lineSkipped
+	}
+	record := sl.record
+
+	if !p.inDateRange(record.createdUTC()) || !p.subredditAllowed(record.subredditName()) {
+		fs.RowsFiltered++
+		return 0, lineSkipped
+	}
+	if seenIDs != nil && seenIDs.seenBefore(record.id()) {
+		fs.Duplicates++
+		return 0, lineSkipped
+	}
+
+	subreddit := p.fileNameOf(record)
+	if missingSubreddit(record.subredditName()) {
+		fs.NoSubreddit++
+	}
+	recordBytes := int64(len(record.rawJSON())) + 1
+	if counts != nil {
+		ss, ok := counts[subreddit]
+		if !ok {
+			ss = &SubredditStats{}
+			counts[subreddit] = ss
+		}
+		ss.RowsWritten++
+		ss.BytesOut += recordBytes
+	} else {
+		key := chunkKey{partition: p.partitionOf(record, monthYear), subreddit: subreddit}
+		chunk[key] = append(chunk[key], record)
+	}
+	fs.RowsWritten++
+	fs.BytesOut += recordBytes
+	p.metrics.rowsWritten.Add(1)
+	return recordBytes, lineBuffered
+}
+
+func decodeRecord(kind dumpKind, line []byte) (Record, error) {
+	// The line reader reuses its buffer, so the raw line has to be copied
+	raw := append(json.RawMessage(nil), line...)
+
+	if kind == mixedDump {
+		var keys map[string]json.RawMessage
+		if err := json.Unmarshal(line, &keys); err != nil {
+			return RedditPost{Raw: raw}, err
+		}
+		_, body := keys["body"]
+		_, title := keys["title"]
+		kind = detectKind(body, title)
+	}
+
+	if kind == commentDump {
+		comment := RedditComment{Raw: raw}
+		err := json.Unmarshal(line, &comment)
+		return comment, err
+	}
+	post := RedditPost{Raw: raw}
+	err := json.Unmarshal(line, &post)
+	return post, err
+}
+
+// compactJSON removes the insignificant whitespace of a JSON value.
+func compactJSON(raw json.RawMessage) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(raw))
+	if err := json.Compact(&buf, raw); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// projectFields re-encodes a JSON object with only the given keys, in the
+// given order. Keys the object doesn't have are left out.
+func projectFields(raw json.RawMessage, fields []string) (json.RawMessage, error) {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, field := range fields {
+		value, ok := values[field]
+		if !ok {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(field)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// sourceMonthField holds the month of the dump a post came from when
+// AddSourceMonth is set.
+const sourceMonthField = "source_month"
+
+// addStringField adds key with a string value as the first field of a JSON
+// object.
+func addStringField(raw json.RawMessage, key, value string) (json.RawMessage, error) {
+	trimmed := bytes.TrimLeft(raw, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil, errors.New("not a JSON object")
+	}
+	field, err := json.Marshal(map[string]string{key: value})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(json.RawMessage, 0, len(field)+len(trimmed))
+	out = append(out, field[:len(field)-1]...) // without the closing brace
+	rest := bytes.TrimLeft(trimmed[1:], " \t\r\n")
+	if len(rest) > 0 && rest[0] != '}' {
+		out = append(out, ',')
+	}
+	return append(out, rest...), nil
+}
+
+// writeChunksToDisk appends every subreddit's posts to its output file and
+// removes them from chunk. In dry-run mode the posts are only tallied. When
+// ctx is cancelled it stops between subreddits with ErrInterrupted, leaving
+// the unwritten ones in chunk.
+func (p *Processor) writeChunksToDisk(ctx context.Context, writers *writerCache, chunk map[chunkKey][]Record) error {
+	if p.opts.DryRun {
+		p.tallyChunk(chunk)
+		clear(chunk)
+		return nil
+	}
+	for key, posts := range chunk {
+		if ctx.Err() != nil {
+			return ErrInterrupted
+		}
+		if err := p.writeJSONLChunk(writers, key.partition, key.subreddit, posts); err != nil {
+			return fmt.Errorf("error writing JSONL chunk for %s: %v", key.subreddit, err)
+		}
+		delete(chunk, key)
+	}
+	return nil
+}
+
+func (p *Processor) tallyChunk(chunk map[chunkKey][]Record) {
+	for key, posts := range chunk {
+		var size int64
+		for _, post := range posts {
+			size += int64(len(post.rawJSON())) + 1
+		}
+		p.stats.addSubreddit(key.subreddit, int64(len(posts)), size)
+	}
+}
+
+// writeJSONLChunk appends data to the subreddit's output file, as JSONL or as
+// CSV depending on the configured format. With MaxFileBytes, posts that
+// don't fit go to the next part of the file.
+func (p *Processor) writeJSONLChunk(writers *writerCache, partition, subreddit string, data []Record) error {
+	// Never create a file without posts
+	if len(data) == 0 {
+		return nil
+	}
+	if p.timing != nil {
+		defer p.timing.write.since(time.Now())
+	}
+
+	base, err := p.outputPath(partition, subreddit)
+	if err != nil {
+		return err
+	}
+	// Other workers may append to the same file, e.g. with FlatBySubreddit,
+	// a yearly Partition or dumps of the same month, or pick the part to
+	// write to, so every chunk is written as a whole under the file's lock,
+	// starting from its current size
+	unlock := p.fileLocks.lock(base)
+	defer unlock()
+	if p.written != nil {
+		p.written.add(base)
+	}
+
+	if p.opts.Sort {
+		sortByCreatedUTC(data)
+	}
+
+	var header []byte
+	var rows [][]byte
+	recordSize := func(i int) int64 { return int64(len(data[i].rawJSON())) + 1 }
+	if p.opts.Format == FormatCSV {
+		var err error
+		if header, rows, err = p.encodeCSVRows(data); err != nil {
+			return err
+		}
+		recordSize = func(i int) int64 { return int64(len(rows[i])) }
+	}
+
+	for start := 0; start < len(data); {
+		part := 0
+		if p.opts.MaxFileBytes > 0 {
+			part = p.parts.current(base)
+		}
+		path := p.opts.Format.partPath(base, part)
+		ow, err := writers.get(path)
+		if err != nil {
+			return err
+		}
+		if err := ow.refreshSize(); err != nil {
+			return err
+		}
+
+		// The posts that fit into the part; a single post larger than
+		// MaxFileBytes gets an empty part to itself
+		end := start
+		size := ow.size
+		if size == 0 {
+			size = int64(len(header))
+		}
+		for ; end < len(data); end++ {
+			next := size + recordSize(end)
+			if p.opts.MaxFileBytes > 0 && next > p.opts.MaxFileBytes && (end > start || ow.size > 0) {
+				break
+			}
+			size = next
+		}
+		if end == start {
+			p.parts.advance(base, part)
+			continue
+		}
+
+		if p.opts.Sort {
+			writers.startRun(ow, base)
+		}
+		sizeBefore := ow.size
+		if p.opts.Format == FormatCSV && ow.size == 0 {
+			_, err = ow.Write(header)
+		}
+		for i := start; i < end && err == nil; i++ {
+			if p.opts.Format == FormatCSV {
+				_, err = ow.Write(rows[i])
+				continue
+			}
+			if _, err = ow.Write(data[i].rawJSON()); err == nil {
+				_, err = ow.Write([]byte{'\n'})
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if err := ow.flush(); err != nil {
+			return err
+		}
+
+		first, last := createdRange(data[start:end])
+		if p.mtimes != nil {
+			p.noteMtime(path, last, writers.sourceTime)
+		}
+		posts, written := int64(end-start), ow.size-sizeBefore
+		p.stats.addSubreddit(subreddit, posts, written)
+		p.metrics.bytesOut.Add(written)
+		p.index.add(partition, partName(subreddit, part), p.relCompressedPath(path), posts, written, first, last)
+		start = end
+	}
+	return nil
+}
+
+// Helper functions
+
+// outputPath returns the uncompressed output file of a subreddit and
+// partition, usually a month: <output>/<partition>/<subreddit>.<ext>, or with
+// sharding <output>/<partition>/<shard>/<subreddit>.<ext>, where the shard is
+// the first Shard characters of the lowercased subreddit name. With an
+// OutputTemplate it is wherever the template resolves to.
+func (p *Processor) outputPath(partition, subreddit string) (string, error) {
+	if p.template != nil {
+		return p.templateOutputPath(partition, subreddit)
+	}
+	name := subreddit + p.opts.Format.extension()
+	if p.opts.Shard == 0 {
+		return filepath.Join(p.opts.OutputDir, partition, name), nil
+	}
+	shard := strings.ToLower(subreddit)
+	if len(shard) > p.opts.Shard {
+		shard = shard[:p.opts.Shard]
+	}
+	return filepath.Join(p.opts.OutputDir, partition, shard, name), nil
+}
+
+// defaultMonthPattern matches the months of the dump names, YYYY-MM, and
+// YYYY for yearly dumps.
+var defaultMonthPattern = regexp.MustCompile(`^\d{4}(-(0[1-9]|1[0-2]))?$`)
+
+// unknownMonth is the output directory of dumps without a valid month in
+// their name.
+const unknownMonth = "_unknown"
+
+// parseDumpFilename splits a dump filename like "RC_2023-01.zst" into its kind
+// and month. Names without a known prefix are treated as submissions. With a
+// pattern the month is its "month" group, or the whole name if it doesn't
+// match.
+func parseDumpFilename(filename string, pattern *regexp.Regexp) (dumpKind, string) {
+	name := strings.TrimSuffix(filename, ".zst")
+	kind, month := submissionDump, name
+	for k, prefix := range dumpPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			kind, month = k, strings.TrimPrefix(name, prefix)
+			break
+		}
+	}
+	if pattern != nil {
+		month = name
+		if m := pattern.FindStringSubmatch(name); m != nil && m[pattern.SubexpIndex("month")] != "" {
+			month = m[pattern.SubexpIndex("month")]
+		}
+	}
+	return kind, month
+}
+
+var disallowedNameChars = regexp.MustCompile(`[^\w\-]`)
+
+// sanitizeSubredditName turns a subreddit name into a file name. In
+// NamesASCIIOnly mode characters other than ASCII letters, digits, '_' and
+// '-' are dropped and the result is cut to 50 bytes. NamesUnicodeSafe
+// percent-encodes them instead (including '%' itself), so the original name
+// can be recovered, and only cuts names beyond 200 bytes. Names that end up
+// empty become "_unnamed".
+func sanitizeSubredditName(name string, mode NameMode) string {
+	var sanitized string
+	if mode == NamesUnicodeSafe {
+		sanitized = percentEncodeName(name)
+	} else {
+		sanitized = disallowedNameChars.ReplaceAllString(name, "")
+		if len(sanitized) > 50 {
+			sanitized = sanitized[:50]
+		}
+	}
+	if sanitized == "" {
+		return unnamedSubreddit
+	}
+	return sanitized
+}
+
+func percentEncodeName(name string) string {
+	const maxLen = 200
+
+	var sb strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		isAllowed := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+		encoded := string(c)
+		if !isAllowed {
+			encoded = fmt.Sprintf("%%%02X", c)
+		}
+		// Never cut an escape in half
+		if sb.Len()+len(encoded) > maxLen {
+			break
+		}
+		sb.WriteString(encoded)
+	}
+	return sb.String()
+}
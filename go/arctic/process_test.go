@@ -0,0 +1,402 @@
+package arctic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// newTestProcessor returns a processor over an empty input directory and an
+// output directory in a temporary directory, with opts on top of the
+// defaults set by the caller.
+func newTestProcessor(tb testing.TB, opts Options) *Processor {
+	tb.Helper()
+	if opts.InputDir == "" {
+		opts.InputDir = tb.TempDir()
+	}
+	if opts.OutputDir == "" {
+		opts.OutputDir = tb.TempDir()
+	}
+	opts.Quiet = true
+	p, err := NewProcessor(opts)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return p
+}
+
+// writeDump writes lines as the zstd compressed dump name in dir, each
+// followed by a newline, and returns its path.
+func writeDump(tb testing.TB, dir, name string, lines ...string) string {
+	tb.Helper()
+	var data bytes.Buffer
+	for _, line := range lines {
+		data.WriteString(line)
+		data.WriteByte('\n')
+	}
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.WriteFile(path, encoder.EncodeAll(data.Bytes(), nil), 0644); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+// post returns a submission to subreddit with id, created at created.
+func post(subreddit, id string, created int64) string {
+	return fmt.Sprintf(`{"id":%q,"subreddit":%q,"created_utc":%d,"title":"t"}`, id, subreddit, created)
+}
+
+// readLines returns the lines of the uncompressed output file at path.
+func readLines(tb testing.TB, path string) []string {
+	tb.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestProcessFileZeroLines(t *testing.T) {
+	opts := DefaultOptions()
+	p := newTestProcessor(t, opts)
+	path := writeDump(t, p.opts.InputDir, "RS_2023-01.zst")
+	if err := p.ProcessFile(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	fs := p.Stats().Files[filepath.Base(path)]
+	if fs == nil || fs.RowsRead != 0 || fs.RowsWritten != 0 {
+		t.Errorf("got stats %+v, want 0 rows", fs)
+	}
+	if _, err := os.Stat(filepath.Join(p.opts.OutputDir, "2023-01")); !os.IsNotExist(err) {
+		t.Errorf("an empty dump created its month directory: %v", err)
+	}
+}
+
+func TestProcessFileSingleRecord(t *testing.T) {
+	opts := DefaultOptions()
+	p := newTestProcessor(t, opts)
+	line := post("golang", "a", 1672531200)
+	path := writeDump(t, p.opts.InputDir, "RS_2023-01.zst", line+"\r")
+	if err := p.ProcessFile(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(p.opts.OutputDir, "2023-01", "golang.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != line+"\n" {
+		t.Errorf("got %q, want the post ending with a single newline", data)
+	}
+}
+
+func TestProcessFileCreatesNoEmptyFiles(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Exclude = []string{"gone"}
+	p := newTestProcessor(t, opts)
+	path := writeDump(t, p.opts.InputDir, "RS_2023-01.zst",
+		post("gone", "a", 1672531200),
+		post("kept", "b", 1672531201),
+	)
+	if err := p.ProcessFile(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+
+	writers := newWriterCache(p.opts.MaxOpenFiles, p.retry, p.perm())
+	if err := p.writeJSONLChunk(writers, "2023-01", "empty", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := writers.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(p.opts.OutputDir, "2023-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "kept.jsonl" {
+			t.Errorf("got output file %s for a subreddit without posts", entry.Name())
+		}
+	}
+}
+
+func TestRunTwoDumpsOfTheSameMonth(t *testing.T) {
+	opts := DefaultOptions()
+	opts.OutputCompression = CompressionNone
+	opts.Concurrency = 2
+	opts.ChunkBytes = 16 * 1024
+	p := newTestProcessor(t, opts)
+	const rows = 20000
+	writeSyntheticDump(t, p.opts.InputDir, filepath.Join("a", "RS_2023-01.zst"), rows)
+	writeSyntheticDump(t, p.opts.InputDir, filepath.Join("b", "RS_2023-01.zst"), rows)
+
+	result, err := p.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Completed != 2 {
+		t.Fatalf("completed %d files, want 2", result.Completed)
+	}
+
+	// The chunks of both files are interleaved in the same output files,
+	// but never within a line
+	entries, err := os.ReadDir(filepath.Join(p.opts.OutputDir, "2023-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, entry := range entries {
+		for _, line := range readLines(t, filepath.Join(p.opts.OutputDir, "2023-01", entry.Name())) {
+			if !json.Valid([]byte(line)) {
+				t.Fatalf("%s has a corrupt line %q", entry.Name(), line)
+			}
+			total++
+		}
+	}
+	if total != 2*rows {
+		t.Errorf("got %d posts, want %d", total, 2*rows)
+	}
+}
+
+func TestProcessFileNotJSONL(t *testing.T) {
+	garbage := make([]string, 10)
+	for i := range garbage {
+		garbage[i] = fmt.Sprintf("line %d of a text file", i)
+	}
+	tests := []struct {
+		name    string
+		limit   int64
+		lines   []string
+		aborted bool
+	}{
+		{"garbage", 5, garbage, true},
+		{"fewer lines than the limit", 20, garbage, false},
+		{"check disabled", 0, garbage, false},
+		{"json first", 5, append([]string{post("golang", "a", 1672531200)}, garbage...), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.NotJSONLLines = tt.limit
+			p := newTestProcessor(t, opts)
+			path := writeDump(t, p.opts.InputDir, "RS_2023-01.zst", tt.lines...)
+			err := p.ProcessFile(context.Background(), path)
+			if aborted := errors.Is(err, ErrNotJSONL); aborted != tt.aborted {
+				t.Errorf("got %v, want ErrNotJSONL: %v", err, tt.aborted)
+			}
+			if !tt.aborted && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestProcessFileTimeout(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	// The slow post is in a subreddit that's filtered out, so the timeout
+	// has to fire on lines that aren't buffered
+	lines := []string{
+		post("golang", "a", 1672531200),
+		post("golang", "b", 1672531201),
+		post("skipped", "slow", 1672531202),
+		post("skipped", "c", 1672531203),
+		post("golang", "after", 1672531204),
+	}
+	slow := TransformFunc(func(line []byte) ([]byte, error) {
+		if bytes.Contains(line, []byte(`"slow"`)) {
+			time.Sleep(2 * timeout)
+		}
+		return line, nil
+	})
+	for _, flush := range []bool{false, true} {
+		t.Run(fmt.Sprintf("flush %v", flush), func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.OutputCompression = CompressionNone
+			opts.Timeout = timeout
+			opts.FlushOnTimeout = flush
+			opts.Exclude = []string{"skipped"}
+			opts.Transformers = []PostTransformer{slow}
+			p := newTestProcessor(t, opts)
+			path := writeDump(t, p.opts.InputDir, "RS_2023-01.zst", lines...)
+
+			if err := p.ProcessFile(context.Background(), path); !errors.Is(err, ErrTimeout) {
+				t.Fatalf("got %v, want ErrTimeout", err)
+			}
+			output := filepath.Join(p.opts.OutputDir, "2023-01", "golang.jsonl")
+			if !flush {
+				if _, err := os.Stat(output); !os.IsNotExist(err) {
+					t.Errorf("posts were written without FlushOnTimeout: %v", err)
+				}
+				return
+			}
+			if got := readLines(t, output); !slices.Equal(got, lines[:2]) {
+				t.Errorf("got %q, want the posts read before the timeout", got)
+			}
+			if entry := p.manifest.Files[filepath.Base(path)]; !entry.Partial {
+				t.Errorf("got manifest entry %+v, want it partial", entry)
+			}
+		})
+	}
+}
+
+// writeSyntheticDump writes rows posts from syntheticPosts as the dump name
+// in dir and returns its path.
+func writeSyntheticDump(tb testing.TB, dir, name string, rows int) string {
+	tb.Helper()
+	lines, _ := syntheticPosts(rows)
+	posts := make([]string, len(lines))
+	for i, line := range lines {
+		posts[i] = string(line)
+	}
+	return writeDump(tb, dir, name, posts...)
+}
+
+// truncateFile cuts the file at path to half its size.
+func truncateFile(tb testing.TB, path string) {
+	tb.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()/2); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestProcessFileTruncatedInput(t *testing.T) {
+	p := newTestProcessor(t, DefaultOptions())
+	path := writeSyntheticDump(t, p.opts.InputDir, "RS_2023-01.zst", 10000)
+	truncateFile(t, path)
+
+	err := p.ProcessFile(context.Background(), path)
+	if !errors.Is(err, ErrCorruptInput) {
+		t.Fatalf("got %v, want ErrCorruptInput", err)
+	}
+}
+
+func TestRunMovesCorruptInputAside(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MoveCorrupt = true
+	p := newTestProcessor(t, opts)
+	corrupt := writeSyntheticDump(t, p.opts.InputDir, "RS_2023-01.zst", 10000)
+	truncateFile(t, corrupt)
+	writeDump(t, p.opts.InputDir, "RS_2023-02.zst", post("golang", "a", 1675209600))
+
+	result, _ := p.Run(context.Background())
+	if result.Completed != 1 || result.Failed != 1 {
+		t.Errorf("got %d completed and %d failed files, want 1 of each", result.Completed, result.Failed)
+	}
+	if _, err := os.Stat(corrupt + ".corrupt"); err != nil {
+		t.Errorf("the truncated dump wasn't moved aside: %v", err)
+	}
+	if _, err := os.Stat(corrupt); !os.IsNotExist(err) {
+		t.Errorf("the truncated dump is still in place: %v", err)
+	}
+}
+
+func TestProcessFileKeepsEveryField(t *testing.T) {
+	opts := DefaultOptions()
+	p := newTestProcessor(t, opts)
+
+	// 40 fields in the order of the dumps, not sorted, with nested objects,
+	// unicode escapes and numbers encoding/json would write differently
+	line := `{"title":"Caf\u00e9 \ud83d\ude00 \"quoted\"","subreddit":"golang","id":"zz1","created_utc":1672531200,` +
+		`"author":"gopher","score":1.0e+2,"upvote_ratio":0.950,"num_comments":12,"over_18":false,"spoiler":null,` +
+		`"selftext":"line\nbreak \/ slash","url":"https://go.dev/?a=1&b=<2>","domain":"go.dev","permalink":"/r/golang/comments/zz1/",` +
+		`"author_flair_richtext":[{"e":"text","t":"\u2764"}],"media":{"oembed":{"width":600,"height":338,"html":"\u003ciframe\u003e"}},` +
+		`"gildings":{},"all_awardings":[],"edited":1672531300.5,"stickied":false,"locked":false,"archived":false,` +
+		`"link_flair_text":"discussion","link_flair_css_class":null,"thumbnail":"self","is_self":true,"is_video":false,` +
+		`"num_crossposts":0,"pinned":false,"retrieved_on":1672617600,"subreddit_id":"t5_2rc7j","subreddit_type":"public",` +
+		`"total_awards_received":0,"treatment_tags":[],"ups":100,"pwls":6,"contest_mode":false,` +
+		`"author_fullname":"t2_abc","name":"t3_zz1","preview":{"images":[{"source":{"url":"https://i.redd.it/x.png","width":1,"height":1}}],"enabled":true}}`
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &fields); err != nil || len(fields) != 40 {
+		t.Fatalf("the test post has %d fields, %v", len(fields), err)
+	}
+
+	path := writeDump(t, p.opts.InputDir, "RS_2023-01.zst", line)
+	if err := p.ProcessFile(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(p.opts.OutputDir, "2023-01", "golang.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != line+"\n" {
+		t.Errorf("got\n%s\nwant\n%s", data, line)
+	}
+}
+
+func BenchmarkProcessLines(b *testing.B) {
+	opts := DefaultOptions()
+	p := newTestProcessor(b, opts)
+	lines, size := syntheticPosts(10000)
+	b.SetBytes(size / int64(len(lines)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	fs := &FileStats{}
+	chunk := make(map[chunkKey][]Record)
+	for i := 0; i < b.N; i++ {
+		line := lines[i%len(lines)]
+		if i%len(lines) == 0 {
+			clear(chunk)
+		}
+		sl := p.decodeLine(submissionDump, "2023-01", line, scannedLine{size: len(line) + 1, number: int64(i + 1)})
+		if _, outcome := p.bufferLine(sl, "2023-01", nil, fs, chunk, nil); outcome != lineBuffered {
+			b.Fatalf("line %d wasn't buffered: %v", i, sl.err)
+		}
+	}
+}
+
+func BenchmarkWriteJSONLChunk(b *testing.B) {
+	opts := DefaultOptions()
+	opts.OutputCompression = CompressionNone
+	p := newTestProcessor(b, opts)
+	lines, size := syntheticPosts(10000)
+	fs := &FileStats{}
+	chunk := make(map[chunkKey][]Record)
+	for i, line := range lines {
+		sl := p.decodeLine(submissionDump, "2023-01", line, scannedLine{size: len(line) + 1, number: int64(i + 1)})
+		p.bufferLine(sl, "2023-01", nil, fs, chunk, nil)
+	}
+	b.SetBytes(size)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		writers := newWriterCache(p.opts.MaxOpenFiles, p.retry, p.perm())
+		for key, posts := range chunk {
+			if err := p.writeJSONLChunk(writers, key.partition, key.subreddit, posts); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := writers.Close(); err != nil {
+			b.Fatal(err)
+		}
+
+		// Every iteration writes new files
+		b.StopTimer()
+		if err := os.RemoveAll(filepath.Join(p.opts.OutputDir, "2023-01")); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+	}
+}
@@ -0,0 +1,189 @@
+package arctic
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// dropSmallSubreddits removes the uncompressed output files written to in
+// this run holding fewer than MinPosts posts, counting the parts of rotated
+// files together. Counts aren't known until every input file has been
+// split, so this runs as a pass over the output right before compression.
+// Files of earlier runs, e.g. kept uncompressed, are left alone. It returns
+// the number of subreddit files removed.
+func (p *Processor) dropSmallSubreddits() (int, error) {
+	var paths []string
+	err := filepath.Walk(p.opts.OutputDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, p.opts.Format.extension()) && p.written.contains(p.opts.Format.partBase(path)) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error walking output directory %s: %v", p.opts.OutputDir, err)
+	}
+
+	var bases []string
+	parts := make(map[string][]string)
+	for _, path := range paths {
+		base := p.opts.Format.partBase(path)
+		if _, ok := parts[base]; !ok {
+			bases = append(bases, base)
+		}
+		parts[base] = append(parts[base], path)
+	}
+
+	dropped := 0
+	for _, base := range bases {
+		posts := 0
+		for _, path := range parts[base] {
+			n, err := p.countPosts(path)
+			if err != nil {
+				return dropped, err
+			}
+			posts += n
+		}
+		if posts >= p.opts.MinPosts {
+			continue
+		}
+		for _, path := range parts[base] {
+			if err := os.Remove(path); err != nil {
+				return dropped, fmt.Errorf("error removing %s: %v", path, err)
+			}
+			p.index.remove(p.relCompressedPath(path))
+		}
+		p.log.Debug("dropped subreddit below minimum posts", "path", base, "posts", posts)
+		dropped++
+	}
+	return dropped, nil
+}
+
+// countPosts counts the records of an uncompressed output file, not
+// including the CSV header.
+func (p *Processor) countPosts(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("error opening %s: %v", path, err)
+	}
+	defer file.Close()
+
+	count := 0
+	if p.opts.Format == FormatCSV {
+		// Quoted values may contain newlines, so the rows have to be parsed
+		r := csv.NewReader(bufio.NewReader(file))
+		r.FieldsPerRecord = -1
+		r.ReuseRecord = true
+		for {
+			if _, err := r.Read(); err == io.EOF {
+				break
+			} else if err != nil {
+				return 0, fmt.Errorf("error reading %s: %v", path, err)
+			}
+			count++
+		}
+		return max(count-1, 0), nil
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := file.Read(buf)
+		count += bytes.Count(buf[:n], []byte{'\n'})
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, fmt.Errorf("error reading %s: %v", path, err)
+		}
+	}
+	return count, nil
+}
+
+// pathSet collects paths the workers of all files wrote to, such as the
+// directories pruneEmptyDirs is responsible for.
+type pathSet struct {
+	mu    sync.Mutex
+	paths map[string]struct{}
+}
+
+func newPathSet() *pathSet {
+	return &pathSet{paths: make(map[string]struct{})}
+}
+
+func (s *pathSet) add(path string) {
+	s.mu.Lock()
+	s.paths[path] = struct{}{}
+	s.mu.Unlock()
+}
+
+func (s *pathSet) contains(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.paths[path]
+	return ok
+}
+
+// pruneEmptyDirs removes the directories written to during the run that no
+// longer hold any files, along with the parents below the output directory
+// that become empty that way. It runs once all workers are done.
+func (p *Processor) pruneEmptyDirs() error {
+	if p.touched == nil {
+		return nil
+	}
+	root := filepath.Clean(p.opts.OutputDir)
+	candidates := make(map[string]struct{})
+	p.touched.mu.Lock()
+	for dir := range p.touched.paths {
+		for dir = filepath.Clean(dir); dir != root && isBelow(root, dir); dir = filepath.Dir(dir) {
+			candidates[dir] = struct{}{}
+		}
+	}
+	p.touched.mu.Unlock()
+
+	// Deepest first, so a parent is only looked at after its children
+	dirs := make([]string, 0, len(candidates))
+	for dir := range candidates {
+		dirs = append(dirs, dir)
+	}
+	slices.SortFunc(dirs, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), strings.Compare(a, b))
+	})
+
+	pruned := 0
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("error reading directory %s: %v", dir, err)
+		}
+		if len(entries) > 0 {
+			continue
+		}
+		if err := os.Remove(dir); err != nil {
+			return fmt.Errorf("error removing empty directory %s: %v", dir, err)
+		}
+		p.log.Debug("pruned empty directory", "path", dir)
+		pruned++
+	}
+	p.log.Info("pruned empty directories", "dirs", pruned)
+	return nil
+}
+
+// isBelow reports whether path lies inside root.
+func isBelow(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && filepath.IsLocal(rel)
+}
@@ -0,0 +1,50 @@
+package arctic
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDropSmallSubredditsKeepsEarlierOutput(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MinPosts = 2
+	p := newTestProcessor(t, opts)
+
+	// Left uncompressed by an earlier run
+	old := filepath.Join(p.opts.OutputDir, "2022-12", "small.jsonl")
+	if err := os.MkdirAll(filepath.Dir(old), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(old, []byte(post("small", "x", 1670000000)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeDump(t, p.opts.InputDir, "RS_2023-01.zst",
+		post("big", "a", 1672531200),
+		post("big", "b", 1672531201),
+		post("small", "c", 1672531202),
+	)
+	if err := p.ProcessFile(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	dropped, err := p.dropSmallSubreddits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped != 1 {
+		t.Errorf("dropped %d subreddits, want 1", dropped)
+	}
+
+	for file, want := range map[string]bool{
+		filepath.Join("2023-01", "big.jsonl"):   true,
+		filepath.Join("2023-01", "small.jsonl"): false,
+		filepath.Join("2022-12", "small.jsonl"): true,
+	} {
+		_, err := os.Stat(filepath.Join(p.opts.OutputDir, file))
+		if exists := err == nil; exists != want {
+			t.Errorf("%s exists: %v, want %v", file, exists, want)
+		}
+	}
+}
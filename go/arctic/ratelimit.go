@@ -0,0 +1,75 @@
+package arctic
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// tokenBucket limits a rate of bytes per second, allowing bursts of up to a
+// second's worth after idling. It is safe for concurrent use.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64 // negative while readers are waiting
+	last   time.Time
+}
+
+func newTokenBucket(mbps float64) *tokenBucket {
+	return &tokenBucket{rate: mbps * 1024 * 1024, last: time.Now()}
+}
+
+// take takes n bytes from the bucket, waiting until they are available or
+// ctx is cancelled. Bytes are taken after they were read, so the bucket may
+// go into debt, which later callers wait out.
+func (b *tokenBucket) take(ctx context.Context, n int) {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+	if wait <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// readLimits returns the buckets a worker's reads are throttled by: its
+// own one for Options.MaxReadMBps and the shared one for
+// Options.MaxTotalReadMBps.
+func (p *Processor) readLimits() []*tokenBucket {
+	var buckets []*tokenBucket
+	if p.opts.MaxReadMBps > 0 {
+		buckets = append(buckets, newTokenBucket(p.opts.MaxReadMBps))
+	}
+	if p.readLimit != nil {
+		buckets = append(buckets, p.readLimit)
+	}
+	return buckets
+}
+
+// limitedReader throttles the reads of r to the rate of every bucket. It
+// stops waiting once ctx is cancelled, leaving the cancellation to the
+// caller, so it doesn't look like an error reading r.
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	buckets []*tokenBucket
+}
+
+func (lr limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	for _, b := range lr.buckets {
+		b.take(lr.ctx, n)
+	}
+	return n, err
+}
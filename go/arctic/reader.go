@@ -0,0 +1,368 @@
+package arctic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// errLineTooLong is reported for lines exceeding -max-line-size.
+var errLineTooLong = errors.New("line exceeds the maximum line size")
+
+// RecordDelimiter is how Options.RecordDelimiter splits the dumps into
+// records. Besides the constants, any single character can be used.
+type RecordDelimiter string
+
+const (
+	RecordDelimiterNewline RecordDelimiter = "newline" // one record per line
+	RecordDelimiterNUL     RecordDelimiter = "nul"     // records end with a NUL byte
+	RecordDelimiterJSON    RecordDelimiter = "json"    // concatenated JSON values, see jsonCompactor
+)
+
+// splitter returns the byte ending a record, or json if records are split at
+// the end of every JSON value.
+func (d RecordDelimiter) splitter() (delim byte, json bool, err error) {
+	switch d {
+	case "", RecordDelimiterNewline:
+		return '\n', false, nil
+	case RecordDelimiterNUL:
+		return 0, false, nil
+	case RecordDelimiterJSON:
+		return 0, true, nil
+	}
+	if len(d) != 1 {
+		return 0, false, fmt.Errorf("invalid record delimiter %q: must be %s, %s, %s or a single character",
+			string(d), RecordDelimiterNewline, RecordDelimiterNUL, RecordDelimiterJSON)
+	}
+	return d[0], false, nil
+}
+
+// lineReader splits a stream into lines like bufio.Scanner, but grows its
+// buffer as needed instead of failing on long lines. Lines longer than maxSize
+// (0 means unlimited) are truncated to maxSize, the rest is discarded and
+// TooLong reports true, so the caller can skip just that line.
+//
+// Readers from newRecordReader split dumps into records instead, which are
+// made to fit on one line of the output.
+type lineReader struct {
+	r       *bufio.Reader
+	buf     []byte
+	line    []byte
+	maxSize int
+	tooLong bool
+	err     error
+
+	delim     byte           // ends a line, a newline unless set by newRecordReader
+	values    *jsonCompactor // splits at the end of JSON values instead, if set
+	compacted []byte
+}
+
+func newLineReader(r io.Reader, bufSize, maxSize int) *lineReader {
+	return &lineReader{
+		r:       bufio.NewReaderSize(r, bufSize),
+		maxSize: maxSize,
+		delim:   '\n',
+	}
+}
+
+// newRecordReader returns a reader splitting a dump into records at
+// delimiter.
+func newRecordReader(r io.Reader, bufSize, maxSize int, delimiter RecordDelimiter) (*lineReader, error) {
+	delim, json, err := delimiter.splitter()
+	if err != nil {
+		return nil, err
+	}
+	lr := newLineReader(r, bufSize, maxSize)
+	lr.delim = delim
+	if json {
+		lr.values = &jsonCompactor{}
+	}
+	return lr, nil
+}
+
+// Scan advances to the next line, which is then available through Bytes.
+// It returns false at the end of the input or on a read error.
+func (lr *lineReader) Scan() bool {
+	if lr.values != nil {
+		return lr.scanJSON()
+	}
+	lr.buf = lr.buf[:0]
+	lr.tooLong = false
+	for {
+		chunk, err := lr.r.ReadSlice(lr.delim)
+
+		// Common case: the whole line fits in the read buffer
+		if err == nil && len(lr.buf) == 0 && (lr.maxSize <= 0 || len(chunk)-1 <= lr.maxSize) {
+			lr.line = lr.dropDelim(chunk)
+			return true
+		}
+
+		n := len(chunk)
+		if err == nil {
+			n-- // the newline doesn't count towards the line size
+		}
+		switch {
+		case lr.tooLong:
+		case lr.maxSize > 0 && len(lr.buf)+n > lr.maxSize:
+			lr.buf = append(lr.buf, chunk[:lr.maxSize-len(lr.buf)]...)
+			lr.tooLong = true
+		default:
+			lr.buf = append(lr.buf, chunk...)
+		}
+
+		switch err {
+		case nil:
+			lr.line = lr.dropDelim(lr.buf)
+			return true
+		case bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+			if len(lr.buf) == 0 && !lr.tooLong {
+				return false
+			}
+			lr.line = lr.dropDelim(lr.buf)
+			return true
+		default:
+			lr.err = err
+			return false
+		}
+	}
+}
+
+// dropDelim removes the delimiter ending line. Records split at another
+// delimiter than a newline may span lines; they are compacted, unless
+// truncated.
+func (lr *lineReader) dropDelim(line []byte) []byte {
+	if lr.delim == '\n' {
+		return dropEOL(line)
+	}
+	line = bytes.TrimSuffix(line, []byte{lr.delim})
+	if lr.tooLong || !hasControl(line) {
+		return line
+	}
+	var jc jsonCompactor
+	lr.compacted = lr.compacted[:0]
+	for len(line) > 0 {
+		var n int
+		var end bool
+		lr.compacted, n, end = jc.scan(lr.compacted, line)
+		line = line[n:]
+		if end {
+			jc = jsonCompactor{}
+		}
+	}
+	return lr.compacted
+}
+
+// scanJSON advances to the end of the next JSON value, scanning what the
+// reader has buffered at a time. A value left unfinished by the end of the
+// input is returned as is, so it's reported as unparseable.
+func (lr *lineReader) scanJSON() bool {
+	lr.buf = lr.buf[:0]
+	lr.tooLong = false
+	*lr.values = jsonCompactor{}
+	for {
+		if lr.r.Buffered() == 0 {
+			if _, err := lr.r.Peek(1); err == io.EOF {
+				lr.line = lr.buf
+				return len(lr.buf) > 0 || lr.tooLong
+			} else if err != nil {
+				lr.err = err
+				return false
+			}
+		}
+		chunk, _ := lr.r.Peek(lr.r.Buffered())
+		var n int
+		var end bool
+		lr.buf, n, end = lr.values.scan(lr.buf, chunk)
+		lr.r.Discard(n)
+		if lr.maxSize > 0 && len(lr.buf) > lr.maxSize {
+			lr.buf = lr.buf[:lr.maxSize]
+			lr.tooLong = true
+		}
+		if end {
+			lr.line = lr.buf
+			return true
+		}
+	}
+}
+
+// Bytes returns the current line without its line ending. The slice is only
+// valid until the next call to Scan.
+func (lr *lineReader) Bytes() []byte {
+	return lr.line
+}
+
+// TooLong reports whether the current line exceeded maxSize and was truncated.
+func (lr *lineReader) TooLong() bool {
+	return lr.tooLong
+}
+
+// Err returns the first non-EOF error encountered while reading.
+func (lr *lineReader) Err() error {
+	return lr.err
+}
+
+func dropEOL(line []byte) []byte {
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	return bytes.TrimSuffix(line, []byte{'\r'})
+}
+
+func hasControl(b []byte) bool {
+	for _, c := range b {
+		if c < 0x20 {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonCompactor rewrites JSON text so that it fits on one line: whitespace
+// outside of strings is dropped, and control characters inside of them, like
+// the literal newlines some dumps contain, are escaped as JSON requires. It
+// also finds where top-level values end, which encoding/json's Decoder can't
+// do for such input, nor resume after a syntax error.
+type jsonCompactor struct {
+	depth    int
+	inString bool
+	escaped  bool
+	scalar   bool // a top-level number, literal or garbage is being read
+}
+
+// jsonStringSpecial marks the bytes that end a plain run of a string.
+var jsonStringSpecial = func() (special [256]bool) {
+	for c := 0; c < 0x20; c++ {
+		special[c] = true
+	}
+	special['"'], special['\\'] = true, true
+	return special
+}()
+
+// scan appends src to dst, rewritten as needed, up to the end of the current
+// top-level value, and returns the bytes of src consumed and whether the
+// value ended. Objects, arrays and strings end with their closing byte,
+// other values before the first byte that can't continue them, which isn't
+// consumed, so 1"a" is two values.
+func (jc *jsonCompactor) scan(dst, src []byte) ([]byte, int, bool) {
+	for i := 0; i < len(src); {
+		if jc.inString {
+			if jc.escaped {
+				jc.escaped = false
+				dst = append(dst, src[i])
+				i++
+				continue
+			}
+			start := i
+			for i < len(src) && !jsonStringSpecial[src[i]] {
+				i++
+			}
+			dst = append(dst, src[start:i]...)
+			if i == len(src) {
+				break
+			}
+			switch c := src[i]; c {
+			case '\\':
+				jc.escaped = true
+				dst = append(dst, c)
+			case '"':
+				jc.inString = false
+				dst = append(dst, c)
+				if jc.depth == 0 {
+					return dst, i + 1, true
+				}
+			default:
+				dst = appendControlEscape(dst, c)
+			}
+			i++
+			continue
+		}
+
+		c := src[i]
+		if jc.scalar && (isJSONSpace(c) || c == '"' || c == '{' || c == '[' || c == '}' || c == ']') {
+			return dst, i, true
+		}
+		i++
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '"':
+			jc.inString = true
+		case '{', '[':
+			jc.depth++
+		case '}', ']':
+			jc.depth--
+			if jc.depth <= 0 {
+				return append(dst, c), i, true
+			}
+		default:
+			jc.scalar = jc.depth == 0
+		}
+		dst = append(dst, c)
+	}
+	return dst, len(src), false
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func appendControlEscape(dst []byte, c byte) []byte {
+	switch c {
+	case '\n':
+		return append(dst, '\\', 'n')
+	case '\r':
+		return append(dst, '\\', 'r')
+	case '\t':
+		return append(dst, '\\', 't')
+	}
+	return fmt.Appendf(dst, "\\u%04x", c)
+}
+
+// countingReader counts the bytes read through it and remembers the first
+// read error other than io.EOF. Both may be read from other goroutines while
+// reads are in progress.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+
+	mu  sync.Mutex
+	err error
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n.Add(int64(n))
+	if err != nil && err != io.EOF {
+		cr.mu.Lock()
+		if cr.err == nil {
+			cr.err = err
+		}
+		cr.mu.Unlock()
+	}
+	return n, err
+}
+
+// readErr returns the first error of the underlying reader.
+func (cr *countingReader) readErr() error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.err
+}
+
+// contextReader fails reads once ctx is cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
@@ -0,0 +1,173 @@
+package arctic
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLineReaderGrows(t *testing.T) {
+	long := strings.Repeat("x", 15<<20)
+	input := "short\n" + long + "\r\nlast"
+	lr := newLineReader(strings.NewReader(input), 4096, 0)
+
+	var got []string
+	for lr.Scan() {
+		if lr.TooLong() {
+			t.Errorf("line %d was truncated without a maximum size", len(got)+1)
+		}
+		got = append(got, string(lr.Bytes()))
+	}
+	if err := lr.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0] != "short" || got[1] != long || got[2] != "last" {
+		t.Errorf("got %d lines, want short, the 15MB line and last", len(got))
+	}
+}
+
+func TestLineReaderTruncatesLongLines(t *testing.T) {
+	input := "0123456789\n" + strings.Repeat("y", 100) + "\nafter"
+	lr := newLineReader(strings.NewReader(input), 16, 10)
+
+	want := []struct {
+		line    string
+		tooLong bool
+	}{
+		{"0123456789", false},
+		{strings.Repeat("y", 10), true},
+		{"after", false},
+	}
+	for i, w := range want {
+		if !lr.Scan() {
+			t.Fatalf("line %d is missing: %v", i+1, lr.Err())
+		}
+		if string(lr.Bytes()) != w.line || lr.TooLong() != w.tooLong {
+			t.Errorf("line %d: got %q, too long %v, want %q, %v", i+1, lr.Bytes(), lr.TooLong(), w.line, w.tooLong)
+		}
+	}
+	if lr.Scan() {
+		t.Errorf("got extra line %q", lr.Bytes())
+	}
+}
+
+func TestProcessFileLongLine(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxLineSize = 20 << 20
+	p := newTestProcessor(t, opts)
+
+	selftext := strings.Repeat("z", 15<<20)
+	long := `{"id":"long","subreddit":"big","created_utc":1672531200,"selftext":"` + selftext + `"}`
+	tooLong := `{"id":"huge","subreddit":"big","created_utc":1672531201,"selftext":"` + selftext + selftext + `"}`
+	path := writeDump(t, p.opts.InputDir, "RS_2023-01.zst",
+		long,
+		tooLong,
+		post("big", "after", 1672531202),
+	)
+	if err := p.ProcessFile(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+
+	// The line over the maximum size is skipped on its own
+	lines := readLines(t, filepath.Join(p.opts.OutputDir, "2023-01", "big.jsonl"))
+	if len(lines) != 2 || lines[0] != long || !strings.Contains(lines[1], `"after"`) {
+		t.Errorf("got %d lines, want the 15MB post and the one after the line over the maximum", len(lines))
+	}
+}
+
+func TestRecordReaderJSON(t *testing.T) {
+	input := "{\n  \"id\": \"a\",\n  \"body\": \"two\nlines\"\n}\n" + // pretty-printed, with a literal newline
+		`{"id":"b","body":"a \"quoted\" }"}{"id":"c"}` + // adjacent, with brackets in a string
+		"  [1, 2]\t1\"a\" true\n" + // an array and scalars
+		`{"id":"d"` // unfinished at the end
+	want := []string{
+		`{"id":"a","body":"two\nlines"}`,
+		`{"id":"b","body":"a \"quoted\" }"}`,
+		`{"id":"c"}`,
+		`[1,2]`,
+		`1`,
+		`"a"`,
+		`true`,
+		`{"id":"d"`,
+	}
+	// Small buffers split values, strings and escapes across reads
+	for _, bufSize := range []int{16, 17, 4096} {
+		lr, err := newRecordReader(strings.NewReader(input), bufSize, 0, RecordDelimiterJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for lr.Scan() {
+			got = append(got, string(lr.Bytes()))
+		}
+		if err := lr.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("buffer of %d bytes: got %q, want %q", bufSize, got, want)
+		}
+	}
+}
+
+func TestRecordReaderJSONTruncates(t *testing.T) {
+	input := `{"id":"` + strings.Repeat("x", 100) + `"} {"id":"b"}`
+	lr, err := newRecordReader(strings.NewReader(input), 16, 20, RecordDelimiterJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lr.Scan() || !lr.TooLong() || len(lr.Bytes()) != 20 {
+		t.Fatalf("got %q, too long %v, want the first 20 bytes", lr.Bytes(), lr.TooLong())
+	}
+	if !lr.Scan() || lr.TooLong() || string(lr.Bytes()) != `{"id":"b"}` {
+		t.Errorf("got %q after the long record, want the next one", lr.Bytes())
+	}
+	if lr.Scan() {
+		t.Errorf("got extra record %q", lr.Bytes())
+	}
+}
+
+func TestRecordReaderNULCompacts(t *testing.T) {
+	input := "{\n\"id\": \"a\"\n}\x00{\"id\":\"b\",\"body\":\"x\ty\"}\x00"
+	lr, err := newRecordReader(strings.NewReader(input), 4096, 0, RecordDelimiterNUL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for lr.Scan() {
+		got = append(got, string(lr.Bytes()))
+	}
+	if want := []string{`{"id":"a"}`, `{"id":"b","body":"x\ty"}`}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func BenchmarkRecordReader(b *testing.B) {
+	lines, size := syntheticPosts(10000)
+	var input bytes.Buffer
+	for _, line := range lines {
+		input.Write(line)
+		input.WriteByte('\n')
+	}
+	for _, delimiter := range []RecordDelimiter{RecordDelimiterNewline, RecordDelimiterJSON} {
+		b.Run(string(delimiter), func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				lr, err := newRecordReader(bytes.NewReader(input.Bytes()), bufferSize, 0, delimiter)
+				if err != nil {
+					b.Fatal(err)
+				}
+				records := 0
+				for lr.Scan() {
+					records++
+				}
+				if records != len(lines) {
+					b.Fatalf("got %d records, want %d", records, len(lines))
+				}
+			}
+		})
+	}
+}
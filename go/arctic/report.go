@@ -0,0 +1,159 @@
+package arctic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const runReportName = "run-report.json"
+
+// maxReportLineErrors caps the unparseable lines the run report lists per
+// file; all of them are in the file's .badlines log.
+const maxReportLineErrors = 100
+
+// File statuses of the run report.
+const (
+	StatusCompleted   = "completed"
+	StatusFailed      = "failed"
+	StatusSkipped     = "skipped"
+	StatusInterrupted = "interrupted"
+	StatusNotStarted  = "not_started"
+)
+
+// RunReport is written to outputDir/run-report.json at the end of a run with
+// Options.RunReport, so an unattended run leaves a single file to inspect.
+type RunReport struct {
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration_ns"`
+	Result   Result        `json:"result"`
+	Error    string        `json:"error,omitempty"` // the error Run returned
+	Files    []*FileReport `json:"files"`
+}
+
+// FileReport is the outcome of a single input file.
+type FileReport struct {
+	Path     string        `json:"path"`
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration_ns"`
+	Stats    *FileStats    `json:"stats,omitempty"`
+
+	// LineErrors are the first maxReportLineErrors unparseable lines.
+	LineErrors []LineError `json:"line_errors,omitempty"`
+}
+
+// LineError is an input line that failed to parse.
+type LineError struct {
+	Line  int64  `json:"line"`
+	Error string `json:"error"`
+}
+
+// fileStatus is the status of a file ProcessFile returned err for.
+func fileStatus(err error) string {
+	switch {
+	case err == nil:
+		return StatusCompleted
+	case errors.Is(err, ErrInterrupted):
+		return StatusInterrupted
+	case errors.Is(err, ErrAlreadyProcessed):
+		return StatusSkipped
+	default:
+		return StatusFailed
+	}
+}
+
+// runReport collects the FileReports while the files are processed.
+type runReport struct {
+	mu      sync.Mutex
+	started time.Time
+	files   map[string]*FileReport
+}
+
+func newRunReport() *runReport {
+	return &runReport{started: time.Now(), files: make(map[string]*FileReport)}
+}
+
+func (r *runReport) file(path string) *FileReport {
+	if path == StdinInput {
+		path = "stdin" // the name ProcessReader is called with
+	}
+	fr, ok := r.files[path]
+	if !ok {
+		fr = &FileReport{Path: path, Status: StatusNotStarted}
+		r.files[path] = fr
+	}
+	return fr
+}
+
+// addFiles lists files as not started.
+func (r *runReport) addFiles(files []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, path := range files {
+		r.file(path)
+	}
+}
+
+// finishFile records the outcome of a file that was started at start.
+func (r *runReport) finishFile(path, status string, err error, start time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fr := r.file(path)
+	fr.Status = status
+	fr.Started = start
+	fr.Duration = time.Since(start)
+	if err != nil {
+		fr.Error = err.Error()
+	}
+}
+
+func (r *runReport) addLineError(path string, line int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fr := r.file(path)
+	if len(fr.LineErrors) < maxReportLineErrors {
+		fr.LineErrors = append(fr.LineErrors, LineError{Line: line, Error: err.Error()})
+	}
+}
+
+// write writes the report of a run that ended with result and runErr to
+// outputDir, taking the counters of every file from stats.
+func (r *runReport) write(outputDir string, result Result, runErr error, stats *RunStats, perm permissions) error {
+	r.mu.Lock()
+	report := RunReport{Started: r.started, Duration: time.Since(r.started), Result: result}
+	if runErr != nil {
+		report.Error = runErr.Error()
+	}
+	stats.mu.Lock()
+	for path, fr := range r.files {
+		fr.Stats = stats.Files[filepath.Base(path)]
+		report.Files = append(report.Files, fr)
+	}
+	sort.Slice(report.Files, func(i, j int) bool { return report.Files[i].Path < report.Files[j].Path })
+	data, err := json.MarshalIndent(report, "", "  ")
+	stats.mu.Unlock()
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error encoding run report: %v", err)
+	}
+
+	path := filepath.Join(outputDir, runReportName)
+	if err := os.MkdirAll(outputDir, perm.dir); err != nil {
+		return fmt.Errorf("error creating directory %s: %v", outputDir, err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, perm.file); err != nil {
+		return fmt.Errorf("error writing run report %s: %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("error replacing run report %s: %v", path, err)
+	}
+	return nil
+}
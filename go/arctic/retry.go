@@ -0,0 +1,67 @@
+package arctic
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"syscall"
+	"time"
+)
+
+// retryPolicy retries operations that fail with transient errors, which
+// happen now and then on network-mounted storage. It sleeps backoff before
+// the first retry and doubles it for every further one. Permanent errors are
+// returned right away.
+type retryPolicy struct {
+	attempts int // total tries, 1 disables retrying
+	backoff  time.Duration
+	log      *slog.Logger
+}
+
+// do runs fn until it succeeds, fails permanently or runs out of attempts.
+// op describes the operation in the log.
+func (r retryPolicy) do(op string, fn func() error) error {
+	backoff := r.backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= r.attempts || !isTransient(err) {
+			return err
+		}
+		r.log.Warn("transient I/O error, retrying", "op", op, "attempt", attempt, "backoff", backoff, "err", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// isTransient reports whether err is worth retrying.
+func isTransient(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.EAGAIN, syscall.EINTR, syscall.EBUSY, syscall.ETIMEDOUT} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryingWriter retries failed writes, continuing after whatever part of
+// the data was already written so nothing is written twice.
+type retryingWriter struct {
+	w     io.Writer
+	retry retryPolicy
+	path  string
+}
+
+func (rw retryingWriter) Write(b []byte) (int, error) {
+	written := 0
+	err := rw.retry.do("write "+rw.path, func() error {
+		n, err := rw.w.Write(b[written:])
+		written += n
+		return err
+	})
+	return written, err
+}
@@ -0,0 +1,282 @@
+package arctic
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// scanBatchSize is the number of lines handed to a decode worker at once.
+const scanBatchSize = 1024
+
+// scannedLine is one line of the input, decoded unless it was sampled out.
+type scannedLine struct {
+	size       int   // bytes including the newline
+	number     int64 // of the line in the input, counting from 1
+	blank      bool  // empty or only whitespace, skipped silently
+	sampledOut bool
+	filtered   bool // by Options.Filter
+	deleted    bool // by Options.DropDeleted
+	dropped    bool // by a transformer
+	record     Record
+	err        error  // why the line can't be used
+	raw        []byte // the line, or its start if it's too long, for the bad line log
+}
+
+// decodeLine turns a line into a record ready for routing: it decodes and
+// optionally validates it, then applies the transformers, the field projection
+// and the source month. It is safe to call concurrently.
+func (p *Processor) decodeLine(kind dumpKind, monthYear string, line []byte, sl scannedLine) scannedLine {
+	sl.raw = line
+	if sl.err != nil || sl.sampledOut || sl.blank {
+		return sl
+	}
+
+	var record Record
+	var err error
+	var start time.Time
+	if p.timing != nil {
+		start = time.Now()
+	}
+	if p.opts.FastDecode {
+		record, err = decodeRecordFast(kind, line)
+	} else {
+		record, err = decodeRecord(kind, line)
+	}
+	if p.timing != nil {
+		p.timing.decode.since(start)
+	}
+	if err == nil && kind == mixedDump && p.opts.Type != "" && recordType(record) != p.opts.Type {
+		sl.filtered = true
+		return sl
+	}
+	if err == nil && p.opts.Strict {
+		err = validateRecord(line)
+	}
+	if err == nil && p.opts.Filter != nil && !p.opts.Filter.Match(line) {
+		sl.filtered = true
+		return sl
+	}
+	if err == nil && p.opts.DropDeleted && p.isDeleted(record, line) {
+		sl.deleted = true
+		return sl
+	}
+	for i := 0; err == nil && i < len(p.opts.Transformers); i++ {
+		var raw []byte
+		if raw, err = p.opts.Transformers[i].Transform(record.rawJSON()); err == nil {
+			if raw == nil {
+				sl.dropped = true
+				return sl
+			}
+			record = record.withRaw(raw)
+		}
+	}
+	if p.opts.CountOnly {
+		sl.record, sl.err = record, err
+		return sl
+	}
+	if err == nil && len(p.opts.Fields) > 0 {
+		var raw []byte
+		if raw, err = projectFields(record.rawJSON(), p.opts.Fields); err == nil {
+			record = record.withRaw(raw)
+		}
+	}
+	if err == nil && p.opts.AddSourceMonth {
+		var raw []byte
+		if raw, err = addStringField(record.rawJSON(), sourceMonthField, monthYear); err == nil {
+			record = record.withRaw(raw)
+		}
+	}
+	// Transformers may return indented JSON, which would break a post over
+	// several lines
+	if err == nil && (p.opts.CompactJSON || len(p.opts.Transformers) > 0 && bytes.ContainsAny(record.rawJSON(), "\r\n")) {
+		var raw []byte
+		if raw, err = compactJSON(record.rawJSON()); err == nil {
+			record = record.withRaw(raw)
+		}
+	}
+	sl.record, sl.err = record, err
+	return sl
+}
+
+// lineScanner yields the scanned lines of an input in their original order.
+// close stops it early; lines.Err may only be read after next returned false
+// or close was called.
+type lineScanner interface {
+	next() (scannedLine, bool)
+	close()
+}
+
+// utf8BOM is stripped from the start of an input.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// lineSource reads lines, applying Limit and Sample.
+type lineSource struct {
+	p     *Processor
+	lines *lineReader
+	read  int64
+}
+
+// readLine returns the next line, which is only valid until the following
+// call, and the scannedLine describing it so far.
+func (src *lineSource) readLine() ([]byte, scannedLine, bool) {
+	if src.p.opts.Limit > 0 && src.read >= src.p.opts.Limit {
+		return nil, scannedLine{}, false
+	}
+	if !src.lines.Scan() {
+		return nil, scannedLine{}, false
+	}
+	src.read++
+
+	line := src.lines.Bytes()
+	sl := scannedLine{size: len(line) + 1, number: src.read}
+	if src.read == 1 {
+		line = bytes.TrimPrefix(line, utf8BOM)
+	}
+	if len(bytes.TrimSpace(line)) == 0 && !src.lines.TooLong() {
+		sl.blank = true
+	} else if src.p.opts.Sample > 1 && (src.read-1)%src.p.opts.Sample != 0 {
+		sl.sampledOut = true
+	} else if src.lines.TooLong() {
+		sl.err = errLineTooLong
+		line = line[:min(len(line), tooLongPreviewSize)]
+	}
+	return line, sl, true
+}
+
+// newLineScanner decodes lines on the calling goroutine, or with
+// WorkersPerFile > 1 on that many goroutines.
+func (p *Processor) newLineScanner(lines *lineReader, kind dumpKind, monthYear string) lineScanner {
+	src := &lineSource{p: p, lines: lines}
+	if p.opts.WorkersPerFile > 1 {
+		return newParallelScanner(src, kind, monthYear, p.opts.WorkersPerFile)
+	}
+	return &sequentialScanner{src: src, kind: kind, monthYear: monthYear}
+}
+
+type sequentialScanner struct {
+	src       *lineSource
+	kind      dumpKind
+	monthYear string
+}
+
+func (s *sequentialScanner) next() (scannedLine, bool) {
+	line, sl, ok := s.src.readLine()
+	if !ok {
+		return scannedLine{}, false
+	}
+	return s.src.p.decodeLine(s.kind, s.monthYear, line, sl), true
+}
+
+func (s *sequentialScanner) close() {}
+
+// parallelScanner reads lines into batches on one goroutine, decodes the
+// batches on several and hands them back in order, so the output is the same
+// as with a sequentialScanner. Decoding the JSON is what limits a single
+// file's throughput.
+type parallelScanner struct {
+	results chan lineBatch
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	pending map[int][]scannedLine // decoded batches that came back early
+	nextSeq int
+	current []scannedLine
+	once    sync.Once
+}
+
+type lineBatch struct {
+	seq   int
+	lines []scannedLine
+}
+
+func newParallelScanner(src *lineSource, kind dumpKind, monthYear string, workers int) *parallelScanner {
+	s := &parallelScanner{
+		results: make(chan lineBatch, workers*2),
+		done:    make(chan struct{}),
+		pending: make(map[int][]scannedLine),
+	}
+	jobs := make(chan lineBatch, workers*2)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(jobs)
+		for seq := 0; ; seq++ {
+			batch := lineBatch{seq: seq, lines: make([]scannedLine, 0, scanBatchSize)}
+			for len(batch.lines) < scanBatchSize {
+				line, sl, ok := src.readLine()
+				if !ok {
+					break
+				}
+				// The reader reuses its buffer
+				sl.raw = append([]byte(nil), line...)
+				batch.lines = append(batch.lines, sl)
+			}
+			if len(batch.lines) == 0 {
+				return
+			}
+			select {
+			case jobs <- batch:
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	var workersWg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		workersWg.Add(1)
+		go func() {
+			defer workersWg.Done()
+			for batch := range jobs {
+				for i, sl := range batch.lines {
+					batch.lines[i] = src.p.decodeLine(kind, monthYear, sl.raw, sl)
+				}
+				select {
+				case s.results <- batch:
+				case <-s.done:
+					return
+				}
+			}
+		}()
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		workersWg.Wait()
+		close(s.results)
+	}()
+	return s
+}
+
+func (s *parallelScanner) next() (scannedLine, bool) {
+	for len(s.current) == 0 {
+		if batch, ok := s.pending[s.nextSeq]; ok {
+			delete(s.pending, s.nextSeq)
+			s.current = batch
+			s.nextSeq++
+			continue
+		}
+		batch, ok := <-s.results
+		if !ok {
+			return scannedLine{}, false
+		}
+		s.pending[batch.seq] = batch.lines
+	}
+	sl := s.current[0]
+	s.current = s.current[1:]
+	return sl, true
+}
+
+// close stops the goroutines and waits for them to exit.
+func (s *parallelScanner) close() {
+	s.once.Do(func() {
+		close(s.done)
+		// Unblock workers waiting to hand back a batch
+		for range s.results {
+		}
+		s.wg.Wait()
+	})
+}
@@ -0,0 +1,240 @@
+package arctic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// seekFrameBytes is the uncompressed size after which a compressed output
+// file with a seek index starts a new zstd frame. Reading a record decodes
+// at most one frame.
+const seekFrameBytes = 1 << 20
+
+var seekIndexMagic = [8]byte{'A', 'R', 'C', 'I', 'D', 'X', '0', '1'}
+
+// seekIndexHeader starts a .idx file and is followed by Frames seekFrames.
+// All integers are little endian.
+type seekIndexHeader struct {
+	Magic          [8]byte
+	CompressedSize int64 // of the .zst the index belongs to
+	Records        int64
+	Frames         int64
+}
+
+// seekFrame locates one zstd frame of a compressed output file. Frames only
+// start at the beginning of a record.
+type seekFrame struct {
+	Offset      int64 // in the compressed file
+	RawOffset   int64 // in the uncompressed data
+	FirstRecord int64
+}
+
+// seekIndexName returns the path of the seek index of a compressed output
+// file, "x.zst" becoming "x.idx".
+func seekIndexName(zstPath string) string {
+	return strings.TrimSuffix(zstPath, ".zst") + ".idx"
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+// compressFramed compresses the lines of r to w as a series of independent
+// zstd frames of about seekFrameBytes each, which together are a valid zstd
+// stream. It returns the uncompressed size, the number of records and the
+// frames.
+func compressFramed(ctx context.Context, w io.Writer, r io.Reader, encoderOpts []zstd.EOption) (int64, int64, []seekFrame, error) {
+	out := &countingWriter{w: w}
+	encoder, err := zstd.NewWriter(out, encoderOpts...)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("error creating zstd encoder: %v", err)
+	}
+	// Closing the encoder twice appends a stray checksum, so only a frame
+	// left open by an error is closed on return
+	frameOpen := false
+	defer func() {
+		if frameOpen {
+			encoder.Close()
+		}
+	}()
+
+	var size, records, frameBytes int64
+	var frames []seekFrame
+	inRecord := false
+	reader := bufio.NewReaderSize(contextReader{ctx: ctx, r: r}, 1024*1024)
+	for {
+		line, readErr := reader.ReadSlice('\n')
+		if len(line) > 0 {
+			if frameBytes == 0 && !inRecord {
+				if len(frames) > 0 {
+					encoder.Reset(out)
+				}
+				frames = append(frames, seekFrame{Offset: out.n, RawOffset: size, FirstRecord: records})
+				frameOpen = true
+			}
+			if _, err := encoder.Write(line); err != nil {
+				return 0, 0, nil, err
+			}
+			size += int64(len(line))
+			frameBytes += int64(len(line))
+			inRecord = line[len(line)-1] != '\n'
+			if !inRecord {
+				records++
+				if frameBytes >= seekFrameBytes {
+					frameOpen = false
+					if err := encoder.Close(); err != nil {
+						return 0, 0, nil, err
+					}
+					frameBytes = 0
+				}
+			}
+		}
+		if errors.Is(readErr, bufio.ErrBufferFull) {
+			continue
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return 0, 0, nil, readErr
+		}
+	}
+	if inRecord {
+		records++ // the last line has no newline
+	}
+	if frameOpen {
+		frameOpen = false
+		if err := encoder.Close(); err != nil {
+			return 0, 0, nil, err
+		}
+	}
+	return size, records, frames, nil
+}
+
+// writeSeekIndex writes the index of a compressed file of compressedSize
+// bytes to path, creating it with mode.
+func writeSeekIndex(path string, mode os.FileMode, compressedSize, records int64, frames []seekFrame) error {
+	var buf bytes.Buffer
+	header := seekIndexHeader{Magic: seekIndexMagic, CompressedSize: compressedSize, Records: records, Frames: int64(len(frames))}
+	if err := binary.Write(&buf, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, frames); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), mode)
+}
+
+// IndexedReader reads single records of a compressed output file written
+// with Options.SeekIndex, using its .idx file to only decode the frame that
+// holds the record. It is not safe for concurrent use.
+type IndexedReader struct {
+	file    *os.File
+	size    int64
+	records int64
+	frames  []seekFrame
+	decoder *zstd.Decoder
+}
+
+// OpenIndexed opens the compressed output file at path together with its
+// seek index.
+func OpenIndexed(path string) (*IndexedReader, error) {
+	return OpenIndexedDict(path, nil)
+}
+
+// OpenIndexedDict is OpenIndexed for files compressed with a dictionary, see
+// LoadDictionary.
+func OpenIndexedDict(path string, dict []byte) (*IndexedReader, error) {
+	data, err := os.ReadFile(seekIndexName(path))
+	if err != nil {
+		return nil, fmt.Errorf("error reading seek index of %s: %v", path, err)
+	}
+	r := bytes.NewReader(data)
+	var header seekIndexHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil || header.Magic != seekIndexMagic {
+		return nil, fmt.Errorf("invalid seek index for %s", path)
+	}
+	if header.Frames < 0 || header.Frames*int64(binary.Size(seekFrame{})) != int64(r.Len()) {
+		return nil, fmt.Errorf("invalid seek index for %s: %d frames", path, header.Frames)
+	}
+	frames := make([]seekFrame, header.Frames)
+	if err := binary.Read(r, binary.LittleEndian, frames); err != nil {
+		return nil, fmt.Errorf("invalid seek index for %s: %v", path, err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error getting file info for %s: %v", path, err)
+	}
+	if info.Size() != header.CompressedSize {
+		file.Close()
+		return nil, fmt.Errorf("seek index of %s is for a file of %d bytes, not %d", path, header.CompressedSize, info.Size())
+	}
+	decoder, err := zstd.NewReader(nil, decoderOptions(dict)...)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error creating zstd reader: %v", err)
+	}
+	return &IndexedReader{file: file, size: info.Size(), records: header.Records, frames: frames, decoder: decoder}, nil
+}
+
+// Len returns the number of records in the file.
+func (r *IndexedReader) Len() int64 {
+	return r.records
+}
+
+// Record returns record n, counting from 0, without its newline.
+func (r *IndexedReader) Record(n int64) ([]byte, error) {
+	if n < 0 || n >= r.records {
+		return nil, fmt.Errorf("record %d out of range, the file has %d", n, r.records)
+	}
+	i := sort.Search(len(r.frames), func(i int) bool { return r.frames[i].FirstRecord > n }) - 1
+	end := r.size
+	if i+1 < len(r.frames) {
+		end = r.frames[i+1].Offset
+	}
+	frame := r.frames[i]
+	if err := r.decoder.Reset(io.NewSectionReader(r.file, frame.Offset, end-frame.Offset)); err != nil {
+		return nil, fmt.Errorf("error decoding frame at %d: %v", frame.Offset, err)
+	}
+
+	lines := bufio.NewReader(r.decoder)
+	for skip := n - frame.FirstRecord; ; skip-- {
+		line, err := lines.ReadBytes('\n')
+		if skip == 0 && (err == nil || (err == io.EOF && len(line) > 0)) {
+			return bytes.TrimSuffix(line, []byte{'\n'}), nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading record %d: %v", n, err)
+		}
+	}
+}
+
+// Close closes the file.
+func (r *IndexedReader) Close() error {
+	r.decoder.Close()
+	return r.file.Close()
+}
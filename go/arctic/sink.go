@@ -0,0 +1,130 @@
+package arctic
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Sink receives the finished output files, so they can be stored somewhere
+// other than the local disk, such as an object store. The uncompressed files
+// are appended to while the dumps are split and read back for compression,
+// so they, like the manifest and the index, stay in the output directory;
+// only the compressed files and their seek indexes are handed to the sink.
+type Sink interface {
+	// Create returns a writer for the file at path, relative to the output
+	// and slash-separated. The file is complete once the writer is closed.
+	Create(path string) (io.WriteCloser, error)
+}
+
+// LocalSink writes the output files below Dir, the default sink.
+type LocalSink struct {
+	Dir      string
+	FileMode os.FileMode
+	DirMode  os.FileMode
+}
+
+// Create writes to a temporary file that is synced and renamed into place on
+// Close, so readers never see a partial file.
+func (s LocalSink) Create(path string) (io.WriteCloser, error) {
+	target := filepath.Join(s.Dir, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(target), s.DirMode); err != nil {
+		return nil, fmt.Errorf("error creating directory %s: %v", filepath.Dir(target), err)
+	}
+	file, err := os.OpenFile(target+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, s.FileMode)
+	if err != nil {
+		return nil, fmt.Errorf("error creating file %s: %v", target+".tmp", err)
+	}
+	return &localSinkFile{File: file, target: target}, nil
+}
+
+// move renames the local file src to path, if both are on the same file
+// system, instead of copying it.
+func (s LocalSink) move(src, path string) error {
+	target := filepath.Join(s.Dir, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(target), s.DirMode); err != nil {
+		return fmt.Errorf("error creating directory %s: %v", filepath.Dir(target), err)
+	}
+	return os.Rename(src, target)
+}
+
+type localSinkFile struct {
+	*os.File
+	target string
+}
+
+// abort drops the temporary file after a failed write.
+func (f *localSinkFile) abort() {
+	f.File.Close()
+	os.Remove(f.Name())
+}
+
+func (f *localSinkFile) Close() error {
+	tmpPath := f.Name()
+	if err := f.Sync(); err != nil {
+		f.File.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("error syncing %s: %v", tmpPath, err)
+	}
+	if err := f.File.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error closing %s: %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, f.target); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error renaming %s to %s: %v", tmpPath, f.target, err)
+	}
+	return nil
+}
+
+// publish hands the finished local file src to the sink as path, relative
+// to the output directory, and removes src. A LocalSink gets it with the
+// modification time of src.
+func (p *Processor) publish(src, path string) error {
+	path = filepath.ToSlash(path)
+	local, isLocal := p.opts.Sink.(LocalSink)
+	if isLocal {
+		if err := local.move(src, path); err == nil || !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+		// Another file system, copy it instead
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", src, err)
+	}
+	defer os.Remove(src)
+	defer file.Close()
+	w, err := p.opts.Sink.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %v", path, err)
+	}
+	if _, err := io.Copy(w, file); err != nil {
+		// Closing would complete the file, so sinks able to drop it do
+		if a, ok := w.(interface{ abort() }); ok {
+			a.abort()
+		} else {
+			w.Close()
+		}
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	if isLocal {
+		info, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("error getting file info for %s: %v", src, err)
+		}
+		target := filepath.Join(local.Dir, filepath.FromSlash(path))
+		if err := os.Chtimes(target, time.Time{}, info.ModTime()); err != nil {
+			return fmt.Errorf("error setting the modification time of %s: %v", target, err)
+		}
+	}
+	return nil
+}
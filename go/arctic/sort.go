@@ -0,0 +1,238 @@
+package arctic
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// sortRunBufferSize is the read buffer of every run while merging.
+const sortRunBufferSize = 64 * 1024
+
+// Sorting works as an external merge sort over the chunk boundaries: every
+// chunk is sorted in memory before it is appended, so each append is a
+// sorted run. Once the input file is done, the runs of every touched output
+// file are merged into a single sorted file, by the last worker writing to
+// it. Content that was already in an output file before the first append is
+// treated as one more sorted run.
+
+// sortRuns records where the sorted runs of the output files start, for all
+// workers. The runs of a file are only started and merged under the file's
+// lock, see writeJSONLChunk.
+type sortRuns struct {
+	mu    sync.Mutex
+	files map[string]*sortedFile
+}
+
+type sortedFile struct {
+	starts []int64
+	users  int // workers that started runs and haven't left yet
+}
+
+func newSortRuns() *sortRuns {
+	return &sortRuns{files: make(map[string]*sortedFile)}
+}
+
+// join counts a worker writing runs to path until it leaves.
+func (s *sortRuns) join(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[path]
+	if !ok {
+		f = &sortedFile{}
+		s.files[path] = f
+	}
+	f.users++
+}
+
+// start records a run of path starting at offset.
+func (s *sortRuns) start(path string, offset int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.files[path]
+	if len(f.starts) == 0 && offset > 0 {
+		// Whatever was in the file before counts as the first run
+		f.starts = append(f.starts, 0)
+	}
+	f.starts = append(f.starts, offset)
+}
+
+// leave returns the runs of path if the calling worker was the last one
+// writing to it, which then has to merge them.
+func (s *sortRuns) leave(path string) ([]int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.files[path]
+	if f.users--; f.users > 0 {
+		return nil, false
+	}
+	delete(s.files, path)
+	return f.starts, true
+}
+
+// mergeSorted merges the files writers wrote runs to, which have to be
+// closed. Files other workers still write to, and keep open, are left to
+// the last of them.
+func (p *Processor) mergeSorted(writers *writerCache) error {
+	var errs []error
+	for path, base := range writers.runs {
+		unlock := p.fileLocks.lock(base)
+		starts, last := writers.sorted.leave(path)
+		if last {
+			if err := mergeSortedRuns(path, starts, p.opts.FileMode); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		unlock()
+	}
+	clear(writers.runs)
+	return errors.Join(errs...)
+}
+
+func sortByCreatedUTC(data []Record) {
+	sort.SliceStable(data, func(i, j int) bool {
+		return data[i].createdUTC() < data[j].createdUTC()
+	})
+}
+
+// sortKey extracts created_utc from a JSONL line. Lines without one sort
+// first.
+func sortKey(line []byte) float64 {
+	var post struct {
+		CreatedUTC flexibleFloat `json:"created_utc"`
+	}
+	json.Unmarshal(line, &post)
+	return float64(post.CreatedUTC)
+}
+
+// sortedRun is one run of an output file being merged.
+type sortedRun struct {
+	index int
+	lines *lineReader
+	line  []byte
+	key   float64
+}
+
+func (r *sortedRun) advance() (bool, error) {
+	if !r.lines.Scan() {
+		return false, r.lines.Err()
+	}
+	r.line = r.lines.Bytes()
+	r.key = sortKey(r.line)
+	return true, nil
+}
+
+// runHeap orders runs by the key of their current line; ties keep the order
+// of the runs so the merge is stable.
+type runHeap []*sortedRun
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key < h[j].key
+	}
+	return h[i].index < h[j].index
+}
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)   { *h = append(*h, x.(*sortedRun)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	run := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return run
+}
+
+// mergeSortedRuns merges the sorted runs of the JSONL file at path, starting
+// at the given offsets, and replaces the file with the result, which is
+// created with mode.
+func mergeSortedRuns(path string, starts []int64, mode os.FileMode) error {
+	if len(starts) < 2 {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening file %s: %v", path, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("error getting file info for %s: %v", path, err)
+	}
+
+	runs := make([]io.Reader, len(starts))
+	for i, start := range starts {
+		end := info.Size()
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		runs[i] = io.NewSectionReader(file, start, end-start)
+	}
+
+	tmpPath := path + ".sorting"
+	out, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("error creating file %s: %v", tmpPath, err)
+	}
+	defer os.Remove(tmpPath)
+	defer out.Close()
+
+	writer := bufio.NewWriter(out)
+	if err := mergeRuns(writer, runs); err != nil {
+		return fmt.Errorf("error merging the runs of %s: %v", path, err)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing to file %s: %v", tmpPath, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error closing file %s: %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("error replacing %s with its sorted version: %v", path, err)
+	}
+	return nil
+}
+
+// mergeRuns merges the sorted JSONL runs into w, keeping lines with the same
+// created_utc in the order of the runs.
+func mergeRuns(w io.Writer, runs []io.Reader) error {
+	h := make(runHeap, 0, len(runs))
+	for i, r := range runs {
+		run := &sortedRun{index: i, lines: newLineReader(r, sortRunBufferSize, 0)}
+		ok, err := run.advance()
+		if err != nil {
+			return fmt.Errorf("error reading run %d: %v", i, err)
+		}
+		if ok {
+			h = append(h, run)
+		}
+	}
+	heap.Init(&h)
+
+	for h.Len() > 0 {
+		run := h[0]
+		if _, err := w.Write(run.line); err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte{'\n'}); err != nil {
+			return err
+		}
+
+		ok, err := run.advance()
+		if err != nil {
+			return fmt.Errorf("error reading run %d: %v", run.index, err)
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
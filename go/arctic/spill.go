@@ -0,0 +1,192 @@
+package arctic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// chunkSpill moves buffered posts out of memory into a temporary file when
+// they take up more than Options.SpillBytes, until the chunk is flushed to
+// the output files. Every spill appends a segment with the posts grouped by
+// subreddit, so the posts of a subreddit can be read back segment by segment
+// in their original order. A post is spilled as a line of its decoded
+// fields, see spilledRecord, a tab and its JSON, so it's read back as the
+// same record even if a transformer or Fields changed its JSON.
+type chunkSpill struct {
+	dir      string
+	file     *os.File // created on the first spill
+	size     int64
+	segments []map[chunkKey]spillRange
+}
+
+// spilledRecord holds the decoded fields of a spilled post, one of them set
+// depending on its type.
+type spilledRecord struct {
+	Post    *RedditPost    `json:"p,omitempty"`
+	Comment *RedditComment `json:"c,omitempty"`
+}
+
+// spillRange is where a subreddit's posts are in a segment.
+type spillRange struct {
+	offset, length int64
+}
+
+func newChunkSpill(dir string) *chunkSpill {
+	return &chunkSpill{dir: dir}
+}
+
+// write appends chunk as a new segment.
+func (s *chunkSpill) write(chunk map[chunkKey][]Record) error {
+	if s.file == nil {
+		file, err := os.CreateTemp(s.dir, "arctic-spill-*")
+		if err != nil {
+			return fmt.Errorf("error creating spill file in %s: %v", s.dir, err)
+		}
+		s.file = file
+	}
+
+	segment := make(map[chunkKey]spillRange, len(chunk))
+	w := bufio.NewWriter(io.NewOffsetWriter(s.file, s.size))
+	for key, records := range chunk {
+		start := s.size
+		for _, record := range records {
+			var spilled spilledRecord
+			switch r := record.(type) {
+			case RedditComment:
+				spilled.Comment = &r
+			case RedditPost:
+				spilled.Post = &r
+			}
+			fields, err := json.Marshal(spilled)
+			if err != nil {
+				return fmt.Errorf("error encoding spilled post: %v", err)
+			}
+			w.Write(fields)
+			w.WriteByte('\t')
+			w.Write(record.rawJSON())
+			w.WriteByte('\n')
+			s.size += int64(len(fields)+len(record.rawJSON())) + 2
+		}
+		segment[key] = spillRange{offset: start, length: s.size - start}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing spill file %s: %v", s.file.Name(), err)
+	}
+	s.segments = append(s.segments, segment)
+	return nil
+}
+
+// keys returns the subreddits with spilled posts.
+func (s *chunkSpill) keys() []chunkKey {
+	seen := make(map[chunkKey]struct{})
+	var keys []chunkKey
+	for _, segment := range s.segments {
+		for key := range segment {
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].partition != keys[j].partition {
+			return keys[i].partition < keys[j].partition
+		}
+		return keys[i].subreddit < keys[j].subreddit
+	})
+	return keys
+}
+
+// take reads the spilled posts of key back, as the records they were, and
+// forgets them.
+func (s *chunkSpill) take(key chunkKey) ([]Record, error) {
+	var records []Record
+	for _, segment := range s.segments {
+		r, ok := segment[key]
+		if !ok {
+			continue
+		}
+		lines := newLineReader(io.NewSectionReader(s.file, r.offset, r.length), bufferSize, 0)
+		for lines.Scan() {
+			fields, raw, ok := bytes.Cut(lines.Bytes(), []byte{'\t'})
+			var spilled spilledRecord
+			if !ok || json.Unmarshal(fields, &spilled) != nil {
+				return nil, fmt.Errorf("error reading spill file %s: invalid line", s.file.Name())
+			}
+			raw = append(json.RawMessage(nil), raw...)
+			switch {
+			case spilled.Comment != nil:
+				records = append(records, spilled.Comment.withRaw(raw))
+			case spilled.Post != nil:
+				records = append(records, spilled.Post.withRaw(raw))
+			default:
+				return nil, fmt.Errorf("error reading spill file %s: a post without fields", s.file.Name())
+			}
+		}
+		if err := lines.Err(); err != nil {
+			return nil, fmt.Errorf("error reading spill file %s: %v", s.file.Name(), err)
+		}
+		delete(segment, key)
+	}
+	return records, nil
+}
+
+// reset empties the spill once everything was taken.
+func (s *chunkSpill) reset() error {
+	s.segments = nil
+	s.size = 0
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Truncate(0)
+}
+
+func (s *chunkSpill) empty() bool {
+	return len(s.segments) == 0
+}
+
+// close removes the spill file.
+func (s *chunkSpill) close() error {
+	if s.file == nil {
+		return nil
+	}
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
+
+// flushChunk writes the spilled posts and then chunk to the output files,
+// one subreddit at a time, so at most one subreddit's spilled posts are read
+// back into memory at once. Like writeChunksToDisk it stops between
+// subreddits with ErrInterrupted when ctx is cancelled, leaving the rest in
+// the spill and in chunk. spill may be nil.
+func (p *Processor) flushChunk(ctx context.Context, writers *writerCache, chunk map[chunkKey][]Record, spill *chunkSpill) error {
+	if p.opts.SerializePartitions && !p.opts.DryRun {
+		unlock := p.lockPartitions(chunk, spill)
+		defer unlock()
+	}
+	if spill != nil && !spill.empty() {
+		for _, key := range spill.keys() {
+			if ctx.Err() != nil {
+				return ErrInterrupted
+			}
+			records, err := spill.take(key)
+			if err != nil {
+				return err
+			}
+			if err := p.writeJSONLChunk(writers, key.partition, key.subreddit, append(records, chunk[key]...)); err != nil {
+				return fmt.Errorf("error writing JSONL chunk for %s: %v", key.subreddit, err)
+			}
+			delete(chunk, key)
+		}
+		if err := spill.reset(); err != nil {
+			return fmt.Errorf("error truncating spill file: %v", err)
+		}
+	}
+	return p.writeChunksToDisk(ctx, writers, chunk)
+}
@@ -0,0 +1,66 @@
+package arctic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestChunkSpillKeepsRecords(t *testing.T) {
+	spill := newChunkSpill(t.TempDir())
+	defer spill.close()
+	key := chunkKey{partition: "2023-01", subreddit: "golang"}
+
+	// The JSON of the first two was projected, so it lacks the fields
+	first := []Record{
+		RedditPost{ID: "a", Subreddit: "golang", Author: "gopher", Domain: "go.dev", CreatedUTC: 1672531200.5, Raw: json.RawMessage(`{"id":"a"}`)},
+		RedditComment{ID: "b", Subreddit: "golang", CreatedUTC: 1672531201, Body: "hi", LinkID: "t3_a", ParentID: "t3_a", Raw: json.RawMessage(`{"id":"b"}`)},
+	}
+	second := []Record{
+		RedditPost{ID: "c", Subreddit: "golang", CreatedUTC: 1672531202, Raw: json.RawMessage(`{"id": "c",	"title": "tab"}`)},
+	}
+	for _, records := range [][]Record{first, second} {
+		if err := spill.write(map[chunkKey][]Record{key: records}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := spill.take(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(first, second...); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if records, err := spill.take(key); err != nil || len(records) != 0 {
+		t.Errorf("got %d records taken twice, %v", len(records), err)
+	}
+}
+
+func TestProcessFileSpillSortsProjectedPosts(t *testing.T) {
+	opts := DefaultOptions()
+	opts.OutputCompression = CompressionNone
+	opts.TmpDir = t.TempDir()
+	opts.SpillBytes = 1
+	opts.Sort = true
+	opts.Fields = []string{"id"}
+	p := newTestProcessor(t, opts)
+
+	// Newest first, without created_utc once projected
+	var lines, want []string
+	for i := range 10 {
+		lines = append(lines, post("golang", fmt.Sprint(9-i), 1672531200+int64(9-i)))
+		want = append(want, fmt.Sprintf(`{"id":"%d"}`, i))
+	}
+	path := writeDump(t, p.opts.InputDir, "RS_2023-01.zst", lines...)
+	if err := p.ProcessFile(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLines(t, filepath.Join(p.opts.OutputDir, "2023-01", "golang.jsonl")); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
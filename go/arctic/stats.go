@@ -0,0 +1,261 @@
+package arctic
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// FileStats are the counters of a single input file.
+type FileStats struct {
+	RowsRead     int64 `json:"rows_read"`
+	RowsWritten  int64 `json:"rows_written"`
+	RowsFiltered int64 `json:"rows_filtered"`
+	RowsDropped  int64 `json:"rows_dropped"` // by a PostTransformer
+	RowsDeleted  int64 `json:"rows_deleted"` // by Options.DropDeleted
+	ParseErrors  int64 `json:"parse_errors"`
+	Duplicates   int64 `json:"duplicates"`
+	NoSubreddit  int64 `json:"no_subreddit"` // rows written without a subreddit
+	BytesIn      int64 `json:"bytes_in"`
+	BytesOut     int64 `json:"bytes_out"`
+
+	// Started and Finished are the wall clock times the file was processed
+	// in. CPUTime is its share of the CPU time of the process meanwhile, see
+	// cpuClock; comparing it to the wall time tells whether the file was
+	// waiting on I/O or on the CPU. It's 0 where the CPU time isn't known.
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	CPUTime  time.Duration `json:"cpu_time_ns"`
+}
+
+// WallTime is how long the file took.
+func (fs *FileStats) WallTime() time.Duration {
+	return fs.Finished.Sub(fs.Started)
+}
+
+// SubredditStats are the counters of a single subreddit across all files.
+type SubredditStats struct {
+	RowsWritten int64 `json:"rows_written"`
+	BytesOut    int64 `json:"bytes_out"`
+}
+
+// RunStats accumulates counters from all workers. Bytes are measured on the
+// decompressed JSONL, including the trailing newline of every row. The maps
+// must not be accessed while a run is in progress.
+type RunStats struct {
+	mu         sync.Mutex
+	Files      map[string]*FileStats      `json:"files"`
+	Subreddits map[string]*SubredditStats `json:"subreddits"`
+
+	// DroppedSubreddits is the number of output files removed for having
+	// fewer than Options.MinPosts posts.
+	DroppedSubreddits int `json:"dropped_subreddits"`
+
+	// Memory is measured over Processor.Run.
+	Memory MemoryStats `json:"memory"`
+}
+
+func newRunStats() *RunStats {
+	return &RunStats{
+		Files:      make(map[string]*FileStats),
+		Subreddits: make(map[string]*SubredditStats),
+	}
+}
+
+func (s *RunStats) addFile(name string, fs *FileStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Files[name] = fs
+}
+
+func (s *RunStats) addSubreddit(subreddit string, rows, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.Subreddits[subreddit]
+	if !ok {
+		ss = &SubredditStats{}
+		s.Subreddits[subreddit] = ss
+	}
+	ss.RowsWritten += rows
+	ss.BytesOut += bytes
+}
+
+func (s *RunStats) setDropped(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.DroppedSubreddits = n
+}
+
+func (s *RunStats) setMemory(m MemoryStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Memory = m
+}
+
+// PrintSummary prints a table of all files and one of the subreddits with the
+// most rows. limit caps the number of subreddits shown, 0 shows all of them.
+func (s *RunStats) PrintSummary(w io.Writer, limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.Files))
+	for name := range s.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var total FileStats
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "file\trows read\twritten\tfiltered\ttransform dropped\tparse errors\tduplicates\tMB in\tMB out\twall\tCPU\tCPU %\t")
+	for _, name := range names {
+		fs := s.Files[name]
+		printFileStatsRow(tw, name, fs)
+		total.RowsRead += fs.RowsRead
+		total.RowsWritten += fs.RowsWritten
+		total.RowsFiltered += fs.RowsFiltered
+		total.RowsDropped += fs.RowsDropped
+		total.RowsDeleted += fs.RowsDeleted
+		total.ParseErrors += fs.ParseErrors
+		total.Duplicates += fs.Duplicates
+		total.NoSubreddit += fs.NoSubreddit
+		total.BytesIn += fs.BytesIn
+		total.BytesOut += fs.BytesOut
+		total.CPUTime += fs.CPUTime
+		if total.Started.IsZero() || fs.Started.Before(total.Started) {
+			total.Started = fs.Started
+		}
+		if fs.Finished.After(total.Finished) {
+			total.Finished = fs.Finished
+		}
+	}
+	printFileStatsRow(tw, "total", &total)
+	tw.Flush()
+	if total.RowsDeleted > 0 {
+		fmt.Fprintf(w, "\n%d deleted or removed rows were dropped\n", total.RowsDeleted)
+	}
+	if total.NoSubreddit > 0 {
+		fmt.Fprintf(w, "\n%d rows without a subreddit were written to %s\n", total.NoSubreddit, noSubreddit)
+	}
+
+	subreddits := s.sortedSubreddits()
+
+	if s.DroppedSubreddits > 0 {
+		fmt.Fprintf(w, "\n%d subreddit files dropped below the minimum post count\n", s.DroppedSubreddits)
+	}
+
+	fmt.Fprintf(w, "\n%d subreddits", len(subreddits))
+	if limit > 0 && len(subreddits) > limit {
+		fmt.Fprintf(w, ", top %d", limit)
+		subreddits = subreddits[:limit]
+	}
+	fmt.Fprintln(w, ":")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "subreddit\trows\tMB\t")
+	for _, name := range subreddits {
+		ss := s.Subreddits[name]
+		fmt.Fprintf(tw, "%s\t%d\t%.2f\t\n", name, ss.RowsWritten, megabytes(ss.BytesOut))
+	}
+	tw.Flush()
+
+	if m := s.Memory; m.HeapSysBytes > 0 {
+		fmt.Fprintf(w, "\nPeak heap %.2f MB (%.2f MB from the OS), %d GC cycles pausing %s in total, %.2f%% of CPU time in GC\n",
+			megabytes(int64(m.PeakHeapBytes)), megabytes(int64(m.HeapSysBytes)), m.GCCycles,
+			m.GCPauseTotal.Round(time.Microsecond), m.GCCPUFraction*100)
+	}
+}
+
+// sortedSubreddits returns the subreddits with the most rows first. s.mu
+// must be held.
+func (s *RunStats) sortedSubreddits() []string {
+	subreddits := make([]string, 0, len(s.Subreddits))
+	for name := range s.Subreddits {
+		subreddits = append(subreddits, name)
+	}
+	sort.Slice(subreddits, func(i, j int) bool {
+		a, b := s.Subreddits[subreddits[i]], s.Subreddits[subreddits[j]]
+		if a.RowsWritten != b.RowsWritten {
+			return a.RowsWritten > b.RowsWritten
+		}
+		return subreddits[i] < subreddits[j]
+	})
+	return subreddits
+}
+
+// subredditCount is a row of WriteDistribution.
+type subredditCount struct {
+	Subreddit string `json:"subreddit"`
+	Rows      int64  `json:"rows"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// WriteDistribution writes the rows and bytes of every subreddit, most rows
+// first, to path: as a JSON array if it ends in .json, as CSV otherwise.
+func (s *RunStats) WriteDistribution(path string) error {
+	s.mu.Lock()
+	counts := make([]subredditCount, 0, len(s.Subreddits))
+	for _, name := range s.sortedSubreddits() {
+		ss := s.Subreddits[name]
+		counts = append(counts, subredditCount{Subreddit: name, Rows: ss.RowsWritten, Bytes: ss.BytesOut})
+	}
+	s.mu.Unlock()
+
+	var buf bytes.Buffer
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		data, err := json.MarshalIndent(counts, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding distribution: %v", err)
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	} else {
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"subreddit", "rows", "bytes"})
+		for _, c := range counts {
+			w.Write([]string{c.Subreddit, strconv.FormatInt(c.Rows, 10), strconv.FormatInt(c.Bytes, 10)})
+		}
+		w.Flush()
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing distribution to %s: %v", path, err)
+	}
+	return nil
+}
+
+func printFileStatsRow(w *tabwriter.Writer, name string, fs *FileStats) {
+	// More than 100% means more than one core was busy on average
+	cpuPercent := "-"
+	if wall := fs.WallTime(); wall > 0 && fs.CPUTime > 0 {
+		cpuPercent = fmt.Sprintf("%.0f", float64(fs.CPUTime)/float64(wall)*100)
+	}
+	fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%.2f\t%.2f\t%s\t%s\t%s\t\n", name, fs.RowsRead, fs.RowsWritten,
+		fs.RowsFiltered, fs.RowsDropped, fs.ParseErrors, fs.Duplicates, megabytes(fs.BytesIn), megabytes(fs.BytesOut),
+		fs.WallTime().Round(time.Millisecond), fs.CPUTime.Round(time.Millisecond), cpuPercent)
+}
+
+// WriteJSON writes all counters to path.
+func (s *RunStats) WriteJSON(path string) error {
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error encoding stats: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing stats to %s: %v", path, err)
+	}
+	return nil
+}
+
+func megabytes(n int64) float64 {
+	return float64(n) / (1024 * 1024)
+}
@@ -0,0 +1,71 @@
+package arctic
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// templateData are the variables of Options.OutputTemplate.
+type templateData struct {
+	Subreddit string   // the sanitized name of the output file, see PartitionKey
+	Month     string   // the partition, e.g. "2023-01", or "2023-01-02" by day
+	Year      string   // the year of Month
+	Type      DumpType // submissions or comments
+}
+
+// parseOutputTemplate parses an output template and checks that it resolves
+// to a file below the output directory.
+func parseOutputTemplate(text string, format OutputFormat) (*template.Template, error) {
+	tmpl, err := template.New("output").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output template %q: %v", text, err)
+	}
+	example := templateData{Subreddit: "AskReddit", Month: "2023-01", Year: "2023", Type: DumpSubmissions}
+	if _, err := renderOutputPath(tmpl, example, format); err != nil {
+		return nil, fmt.Errorf("invalid output template %q: %v", text, err)
+	}
+	return tmpl, nil
+}
+
+// renderOutputPath resolves tmpl to the path of an output file relative to
+// the output directory, with the extension of format whether or not the
+// template ends with it.
+func renderOutputPath(tmpl *template.Template, data templateData, format OutputFormat) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	rel := strings.TrimSuffix(b.String(), format.extension())
+	rel = filepath.Clean(filepath.FromSlash(rel))
+	if !filepath.IsLocal(rel) || strings.HasPrefix(filepath.Base(rel), ".") {
+		return "", fmt.Errorf("%q is not a file name below the output directory", b.String())
+	}
+	return rel + format.extension(), nil
+}
+
+// templateOutputPath is outputPath with Options.OutputTemplate. The
+// partition holds the type of the posts and their month, see partitionOf.
+func (p *Processor) templateOutputPath(partition, subreddit string) (string, error) {
+	dumpType, month, _ := strings.Cut(partition, "/")
+	year := month
+	if len(month) >= 4 && isDigits(month[:4]) {
+		year = month[:4]
+	}
+	data := templateData{Subreddit: subreddit, Month: month, Year: year, Type: DumpType(dumpType)}
+	rel, err := renderOutputPath(p.template, data, p.opts.Format)
+	if err != nil {
+		return "", fmt.Errorf("error resolving output template for %s in %s: %v", subreddit, partition, err)
+	}
+	return filepath.Join(p.opts.OutputDir, rel), nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package arctic
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// latencyHistogram counts durations in exponential buckets, four per power
+// of two, so percentiles are accurate to within about 12% at any scale. It
+// is safe for concurrent use and never allocates.
+type latencyHistogram struct {
+	buckets [252]atomic.Int64
+	count   atomic.Int64
+	sum     atomic.Int64
+	max     atomic.Int64
+}
+
+// bucketOf returns the bucket of a duration of v nanoseconds.
+func bucketOf(v uint64) int {
+	if v < 4 {
+		return int(v)
+	}
+	l := bits.Len64(v)
+	return (l-2)*4 + int(v>>(l-3)&3)
+}
+
+// bucketStart returns the smallest duration in nanoseconds of bucket i.
+func bucketStart(i int) uint64 {
+	if i < 4 {
+		return uint64(i)
+	}
+	return uint64(4|i%4) << (i/4 - 1)
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	v := max(int64(d), 0)
+	h.buckets[bucketOf(uint64(v))].Add(1)
+	h.count.Add(1)
+	h.sum.Add(v)
+	for {
+		m := h.max.Load()
+		if v <= m || h.max.CompareAndSwap(m, v) {
+			return
+		}
+	}
+}
+
+// since observes the time elapsed since start.
+func (h *latencyHistogram) since(start time.Time) {
+	h.observe(time.Since(start))
+}
+
+// percentile returns the duration below which a fraction q of the
+// observations fall, taking the middle of its bucket.
+func (h *latencyHistogram) percentile(q float64) time.Duration {
+	target := int64(q * float64(h.count.Load()))
+	var seen int64
+	for i := range h.buckets {
+		seen += h.buckets[i].Load()
+		if seen > target {
+			start, end := bucketStart(i), bucketStart(i+1)
+			return min(time.Duration(start+(end-start)/2), time.Duration(h.max.Load()))
+		}
+	}
+	return time.Duration(h.max.Load())
+}
+
+// TimingStats is the distribution of the time one stage of the pipeline
+// took, see Options.ProfileTiming.
+type TimingStats struct {
+	Stage string        `json:"stage"`
+	Count int64         `json:"count"`
+	Mean  time.Duration `json:"mean_ns"`
+	P50   time.Duration `json:"p50_ns"`
+	P95   time.Duration `json:"p95_ns"`
+	P99   time.Duration `json:"p99_ns"`
+	Max   time.Duration `json:"max_ns"`
+}
+
+// timingProfile holds the histograms of the instrumented stages.
+type timingProfile struct {
+	decode latencyHistogram // decoding a line into a record
+	write  latencyHistogram // writing the chunk of a subreddit
+}
+
+func (t *timingProfile) stats() []TimingStats {
+	var stats []TimingStats
+	for _, stage := range []struct {
+		name string
+		h    *latencyHistogram
+	}{{"decode line", &t.decode}, {"write chunk", &t.write}} {
+		count := stage.h.count.Load()
+		if count == 0 {
+			continue
+		}
+		stats = append(stats, TimingStats{
+			Stage: stage.name,
+			Count: count,
+			Mean:  time.Duration(stage.h.sum.Load() / count),
+			P50:   stage.h.percentile(0.50),
+			P95:   stage.h.percentile(0.95),
+			P99:   stage.h.percentile(0.99),
+			Max:   time.Duration(stage.h.max.Load()),
+		})
+	}
+	return stats
+}
+
+// Timing returns the timing distributions collected so far, nil unless
+// Options.ProfileTiming is set.
+func (p *Processor) Timing() []TimingStats {
+	if p.timing == nil {
+		return nil
+	}
+	return p.timing.stats()
+}
+
+// PrintTiming prints a table of timing distributions.
+func PrintTiming(w io.Writer, stats []TimingStats) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "stage\tcount\tmean\tp50\tp95\tp99\tmax\t")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t\n", s.Stage, s.Count, formatLatency(s.Mean),
+			formatLatency(s.P50), formatLatency(s.P95), formatLatency(s.P99), formatLatency(s.Max))
+	}
+	tw.Flush()
+}
+
+// formatLatency rounds d to three significant digits or so, unlike
+// formatTime which drops the fractions of microseconds.
+func formatLatency(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		d = d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		d = d.Round(time.Microsecond)
+	case d >= time.Microsecond:
+		d = d.Round(10 * time.Nanosecond)
+	}
+	return d.String()
+}
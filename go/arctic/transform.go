@@ -0,0 +1,85 @@
+package arctic
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// PostTransformer rewrites the JSON of a post after it was parsed and routed
+// to its subreddit and partition, before it is written. Returning nil drops
+// the post, an error makes the line count as unparseable. Transformers run
+// concurrently when WorkersPerFile is above 1.
+type PostTransformer interface {
+	Transform(line []byte) ([]byte, error)
+}
+
+// TransformFunc adapts a function to a PostTransformer.
+type TransformFunc func(line []byte) ([]byte, error)
+
+func (f TransformFunc) Transform(line []byte) ([]byte, error) { return f(line) }
+
+// authorAnonymizer replaces the author of every post with a keyed hash of it.
+type authorAnonymizer struct {
+	salt []byte
+}
+
+// NewAuthorAnonymizer returns a transformer that replaces the "author" field
+// with the hex HMAC-SHA256 of the name keyed with salt, so the same author
+// gets the same pseudonym as long as the salt doesn't change. Deleted and
+// missing authors are left alone, and so are other fields that identify the
+// author such as author_fullname.
+func NewAuthorAnonymizer(salt string) PostTransformer {
+	return authorAnonymizer{salt: []byte(salt)}
+}
+
+func (a authorAnonymizer) Transform(line []byte) ([]byte, error) {
+	return replaceField(line, "author", func(value json.RawMessage) (json.RawMessage, error) {
+		var author string
+		if json.Unmarshal(value, &author) != nil || author == "" || author == "[deleted]" {
+			return value, nil
+		}
+		mac := hmac.New(sha256.New, a.salt)
+		mac.Write([]byte(author))
+		return json.Marshal(hex.EncodeToString(mac.Sum(nil))[:32])
+	})
+}
+
+// replaceField replaces the value of a top-level key of a JSON object with
+// what replace returns for it, keeping the rest of the object byte for byte.
+// Objects without the key are returned unchanged.
+func replaceField(raw []byte, key string, replace func(json.RawMessage) (json.RawMessage, error)) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil { // the opening brace
+		return nil, err
+	}
+	for dec.More() {
+		name, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		// The value spans the colon after the key up to its end
+		start := dec.InputOffset()
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		if name != key {
+			continue
+		}
+		end := dec.InputOffset()
+
+		replaced, err := replace(value)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, 0, len(raw)-int(end-start)+len(replaced)+1)
+		out = append(out, raw[:start]...)
+		out = append(out, ':')
+		out = append(out, replaced...)
+		return append(out, raw[end:]...), nil
+	}
+	return raw, nil
+}
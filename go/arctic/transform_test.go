@@ -0,0 +1,62 @@
+package arctic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// indent returns line pretty-printed over several lines.
+func indent(tb testing.TB, line string) string {
+	tb.Helper()
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(line), "", "  "); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestProcessFilePrettyPrintedInput(t *testing.T) {
+	first, second := post("golang", "a", 1672531200), post("golang", "b", 1672531201)
+	tests := []struct {
+		name  string
+		setup func(opts *Options)
+		input []string
+	}{
+		{"json records", func(opts *Options) {
+			opts.RecordDelimiter = RecordDelimiterJSON
+		}, []string{indent(t, first), indent(t, second)}},
+		{"nul records", func(opts *Options) {
+			opts.RecordDelimiter = RecordDelimiterNUL
+		}, []string{indent(t, first) + "\x00" + indent(t, second) + "\x00"}},
+		{"compact json", func(opts *Options) {
+			opts.CompactJSON = true
+		}, []string{strings.ReplaceAll(first, ",", " ,\t"), strings.ReplaceAll(second, ":", " : ")}},
+		{"indenting transformer", func(opts *Options) {
+			opts.Transformers = []PostTransformer{TransformFunc(func(line []byte) ([]byte, error) {
+				var buf bytes.Buffer
+				err := json.Indent(&buf, line, "", "\t")
+				return buf.Bytes(), err
+			})}
+		}, []string{first, second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.OutputCompression = CompressionNone
+			tt.setup(&opts)
+			p := newTestProcessor(t, opts)
+			path := writeDump(t, p.opts.InputDir, "RS_2023-01.zst", tt.input...)
+			if err := p.ProcessFile(context.Background(), path); err != nil {
+				t.Fatal(err)
+			}
+			lines := readLines(t, filepath.Join(p.opts.OutputDir, "2023-01", "golang.jsonl"))
+			if len(lines) != 2 || lines[0] != first || lines[1] != second {
+				t.Errorf("got %q, want both posts on a line each", lines)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package arctic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// redditLaunch is the earliest plausible created_utc.
+var redditLaunch = time.Date(2005, time.June, 23, 0, 0, 0, 0, time.UTC)
+
+// validateRecord checks the fields routing depends on in a decoded line:
+// subreddit has to be a non-empty string and created_utc a number between
+// Reddit's launch and a day from now. It is used in strict mode, where
+// records failing it are treated like unparseable lines.
+func validateRecord(line []byte) error {
+	var fields struct {
+		Subreddit  json.RawMessage `json:"subreddit"`
+		CreatedUTC json.RawMessage `json:"created_utc"`
+	}
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return err
+	}
+
+	var subreddit string
+	if len(fields.Subreddit) == 0 || fields.Subreddit[0] != '"' {
+		return errors.New("subreddit is missing or not a string")
+	}
+	if err := json.Unmarshal(fields.Subreddit, &subreddit); err != nil || subreddit == "" {
+		return errors.New("subreddit is empty")
+	}
+
+	if len(fields.CreatedUTC) == 0 {
+		return errors.New("created_utc is missing")
+	}
+	created, err := strconv.ParseFloat(string(fields.CreatedUTC), 64)
+	if err != nil {
+		return fmt.Errorf("created_utc is not a number: %s", fields.CreatedUTC)
+	}
+	latest := time.Now().Add(24 * time.Hour)
+	if created < float64(redditLaunch.Unix()) || created > float64(latest.Unix()) {
+		return fmt.Errorf("created_utc %s is outside %s to %s", fields.CreatedUTC,
+			redditLaunch.Format(time.DateOnly), latest.Format(time.DateOnly))
+	}
+	return nil
+}
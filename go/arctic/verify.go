@@ -0,0 +1,193 @@
+package arctic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxViolationsPerFile caps the violations VerifyOutput keeps for a single
+// file; all of them are still counted.
+const maxViolationsPerFile = 100
+
+// Violation is a problem VerifyOutput found in an output file. Line is 0
+// for problems with the file as a whole.
+type Violation struct {
+	File    string `json:"file"`
+	Line    int64  `json:"line"`
+	Problem string `json:"problem"`
+}
+
+// VerifyReport is the result of VerifyOutput.
+type VerifyReport struct {
+	Files      int         `json:"files"`
+	Records    int64       `json:"records"`
+	Failures   int64       `json:"failures"` // including the ones not listed
+	Violations []Violation `json:"violations"`
+}
+
+// VerifyOutput checks the final files in the output directory of opts
+// without needing the input: every file has to decompress, and for JSONL
+// output every line has to be valid JSON whose subreddit, or other
+// PartitionKey, is the one the file is named after. Posts without the field,
+// e.g. because of a field projection, aren't checked for routing. Files are
+// verified by Concurrency workers.
+func VerifyOutput(ctx context.Context, opts Options) (*VerifyReport, error) {
+	if opts.Concurrency < 1 {
+		return nil, fmt.Errorf("invalid concurrency %d: must be at least 1", opts.Concurrency)
+	}
+	if opts.Format == "" {
+		opts.Format = FormatJSONL
+	}
+	if opts.NameMode == "" {
+		opts.NameMode = NamesASCIIOnly
+	}
+	if opts.PartitionKey == "" {
+		opts.PartitionKey = PartitionBySubreddit
+	}
+	if opts.OutputCompression == "" {
+		opts.OutputCompression = CompressionZstd
+	}
+	names, err := loadSubredditNames(opts.OutputDir, opts.NameMode, opts.LowercaseSubreddits, defaultPermissions)
+	if err != nil {
+		return nil, fmt.Errorf("error loading subreddit names: %v", err)
+	}
+	dict, err := LoadDictionary(opts.OutputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// e.g. ".zst", ".csv.zst" or ".jsonl.gz"
+	suffix := opts.OutputCompression.compressedName(opts.Format.extension(), opts.Format)
+	var paths []string
+	err = filepath.Walk(opts.OutputDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, suffix) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking output directory %s: %v", opts.OutputDir, err)
+	}
+
+	report := &VerifyReport{Files: len(paths)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, opts.Concurrency)
+	for _, path := range paths {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			expected := partSuffix.ReplaceAllString(strings.TrimSuffix(filepath.Base(path), suffix), "")
+			records, failures, violations := verifyFile(ctx, path, opts.Format, opts.OutputCompression, dict, opts.PartitionKey, func(value string) bool {
+				if opts.OutputTemplate != "" {
+					return true // the name needn't be the subreddit
+				}
+				if opts.SingleFile {
+					return expected == singleFile
+				}
+				if opts.PartitionKey == PartitionByAuthor && isDeletedAuthor(value) {
+					return expected == deletedAuthors
+				}
+				if opts.PartitionKey == PartitionBySubreddit && missingSubreddit(value) {
+					return expected == noSubreddit
+				}
+				return names.lookup(value) == expected
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Records += records
+			report.Failures += failures
+			report.Violations = append(report.Violations, violations...)
+		}(path)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ErrInterrupted
+	}
+
+	sort.Slice(report.Violations, func(i, j int) bool {
+		a, b := report.Violations[i], report.Violations[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return report, nil
+}
+
+// verifyFile decompresses the file at path and checks its lines, using
+// belongs to check the value of the partition key of JSONL records. It
+// returns the number of records, of failures and the first
+// maxViolationsPerFile violations.
+func verifyFile(ctx context.Context, path string, format OutputFormat, compression Compression, dict []byte, key PartitionKey, belongs func(string) bool) (int64, int64, []Violation) {
+	var records, failures int64
+	var violations []Violation
+	violation := func(line int64, problem string) {
+		failures++
+		if len(violations) < maxViolationsPerFile {
+			violations = append(violations, Violation{File: path, Line: line, Problem: problem})
+		}
+	}
+
+	decoder, err := openCompressed(path, compression, dict)
+	if err != nil {
+		violation(0, fmt.Sprintf("error opening file: %v", err))
+		return 0, failures, violations
+	}
+	defer decoder.Close()
+
+	lines := newLineReader(contextReader{ctx: ctx, r: decoder}, bufferSize, 0)
+	for lines.Scan() {
+		records++
+		if format != FormatJSONL {
+			continue
+		}
+		var post struct {
+			Subreddit *string `json:"subreddit"`
+			Author    *string `json:"author"`
+			Domain    *string `json:"domain"`
+		}
+		if err := json.Unmarshal(lines.Bytes(), &post); err != nil {
+			violation(records, fmt.Sprintf("invalid JSON: %v", err))
+			continue
+		}
+		value := post.Subreddit
+		switch key {
+		case PartitionByAuthor:
+			if value = post.Author; value == nil {
+				value = new(string) // deleted
+			}
+		case PartitionByDomain:
+			value = post.Domain
+		}
+		if value != nil && !belongs(*value) {
+			violation(records, fmt.Sprintf("post of %s %q in the wrong file", key, *value))
+		}
+	}
+	if err := lines.Err(); err != nil && ctx.Err() == nil {
+		violation(0, fmt.Sprintf("error decompressing after %d records: %v", records, err))
+	}
+	if format == FormatCSV {
+		records = max(records-1, 0) // the header
+	}
+	return records, failures, violations
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package arctic
+
+// watchProgressWidth reads the terminal width. There's no resize signal to
+// keep it current with.
+func watchProgressWidth() {
+	refreshProgressWidth()
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package arctic
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchProgressWidth reads the terminal width and keeps it current on
+// SIGWINCH.
+func watchProgressWidth() {
+	refreshProgressWidth()
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+	go func() {
+		for range resized {
+			refreshProgressWidth()
+		}
+	}()
+}
@@ -0,0 +1,283 @@
+package arctic
+
+import (
+	"bufio"
+	"container/list"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// outputWriter is an open, buffered output file. size counts the bytes of
+// the file including those still buffered.
+type outputWriter struct {
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+	size   int64
+	elem   *list.Element
+}
+
+func (ow *outputWriter) Write(b []byte) (int, error) {
+	n, err := ow.writer.Write(b)
+	ow.size += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("error writing to file %s: %v", ow.path, err)
+	}
+	return n, nil
+}
+
+func (ow *outputWriter) flush() error {
+	if err := ow.writer.Flush(); err != nil {
+		return fmt.Errorf("error writing to file %s: %v", ow.path, err)
+	}
+	return nil
+}
+
+// refreshSize re-reads the size of the file after other writers appended to
+// it. The buffer has to be flushed.
+func (ow *outputWriter) refreshSize() error {
+	info, err := ow.file.Stat()
+	if err != nil {
+		return fmt.Errorf("error getting file info for %s: %v", ow.path, err)
+	}
+	ow.size = info.Size()
+	return nil
+}
+
+func (ow *outputWriter) close() error {
+	flushErr := ow.writer.Flush()
+	closeErr := ow.file.Close()
+	if flushErr != nil {
+		return fmt.Errorf("error writing to file %s: %v", ow.path, flushErr)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("error closing file %s: %v", ow.path, closeErr)
+	}
+	return nil
+}
+
+// writerCache keeps the output files of a ProcessFile call open across
+// chunks instead of reopening them for every flush. At most limit files are
+// open at once: when a new one is needed, the least recently used writer is
+// flushed and closed, and reopened in append mode if it's needed again.
+type writerCache struct {
+	limit   int
+	retry   retryPolicy
+	perm    permissions
+	writers map[string]*outputWriter
+	lru     *list.List // of *outputWriter, most recently used first
+	dirs    map[string]struct{}
+	touched *pathSet // records the directories written to, if set
+
+	// sourceTime is the modification time of the input, for PreserveMtime
+	sourceTime time.Time
+
+	// opened collects the paths opened for the first time since it was
+	// last cleared, if seen is set
+	seen   map[string]struct{}
+	opened []string
+
+	// sorted tracks the sorted runs of every file with Sort, and runs holds
+	// the files this cache wrote runs to, with the path locking each, see
+	// startRun
+	sorted *sortRuns
+	runs   map[string]string
+}
+
+func newWriterCache(limit int, retry retryPolicy, perm permissions) *writerCache {
+	return &writerCache{
+		limit:   max(limit, 1),
+		retry:   retry,
+		perm:    perm,
+		writers: make(map[string]*outputWriter),
+		lru:     list.New(),
+		dirs:    make(map[string]struct{}),
+		runs:    make(map[string]string),
+	}
+}
+
+// get returns the writer for path, opening the file in append mode and
+// creating its directory if needed.
+func (c *writerCache) get(path string) (*outputWriter, error) {
+	if ow, ok := c.writers[path]; ok {
+		c.lru.MoveToFront(ow.elem)
+		return ow, nil
+	}
+
+	for len(c.writers) >= c.limit {
+		if err := c.evict(); err != nil {
+			return nil, err
+		}
+	}
+
+	dir := filepath.Dir(path)
+	if _, ok := c.dirs[dir]; !ok {
+		if err := os.MkdirAll(dir, c.perm.dir); err != nil {
+			return nil, fmt.Errorf("error creating directory %s: %v", dir, err)
+		}
+		c.dirs[dir] = struct{}{}
+		if c.touched != nil {
+			c.touched.add(dir)
+		}
+	}
+
+	var file *os.File
+	err := c.retry.do("open "+path, func() (err error) {
+		file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, c.perm.file)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error opening file %s: %v", path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error getting file info for %s: %v", path, err)
+	}
+
+	size := info.Size()
+	if size > 0 {
+		if size, err = terminateLastLine(file, size); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error checking the end of %s: %v", path, err)
+		}
+	}
+
+	if _, ok := c.seen[path]; !ok && c.seen != nil {
+		c.seen[path] = struct{}{}
+		c.opened = append(c.opened, path)
+	}
+
+	ow := &outputWriter{
+		path:   path,
+		file:   file,
+		writer: bufio.NewWriter(retryingWriter{w: file, retry: c.retry, path: path}),
+		size:   size,
+	}
+	ow.elem = c.lru.PushFront(ow)
+	c.writers[path] = ow
+	return ow, nil
+}
+
+// terminateLastLine makes sure a non-empty file ends with a newline before
+// anything is appended, so a line left incomplete by a crash can't swallow
+// the next one. It returns the new size.
+func terminateLastLine(file *os.File, size int64) (int64, error) {
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, size-1); err != nil {
+		return size, err
+	}
+	if last[0] == '\n' {
+		return size, nil
+	}
+	if _, err := file.Write([]byte{'\n'}); err != nil {
+		return size, err
+	}
+	return size + 1, nil
+}
+
+// startRun marks the current end of ow as the start of a new sorted run.
+// base is the path whose lock the caller holds, see writeJSONLChunk.
+func (c *writerCache) startRun(ow *outputWriter, base string) {
+	if c.sorted == nil {
+		return
+	}
+	if _, ok := c.runs[ow.path]; !ok {
+		c.runs[ow.path] = base
+		c.sorted.join(ow.path)
+	}
+	c.sorted.start(ow.path, ow.size)
+}
+
+// evict flushes and closes the least recently used writer.
+func (c *writerCache) evict() error {
+	elem := c.lru.Back()
+	if elem == nil {
+		return nil
+	}
+	ow := c.lru.Remove(elem).(*outputWriter)
+	delete(c.writers, ow.path)
+	return ow.close()
+}
+
+// Close flushes and closes every open writer. It can be called more than
+// once.
+func (c *writerCache) Close() error {
+	var errs []error
+	for c.lru.Len() > 0 {
+		if err := c.evict(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pathLocks hands out one mutex per output path, for files several workers
+// append to.
+type pathLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newPathLocks() *pathLocks {
+	return &pathLocks{locks: make(map[string]*sync.Mutex)}
+}
+
+// lock locks path and returns the function unlocking it.
+func (l *pathLocks) lock(path string) (unlock func()) {
+	l.mu.Lock()
+	m, ok := l.locks[path]
+	if !ok {
+		m = &sync.Mutex{}
+		l.locks[path] = m
+	}
+	l.mu.Unlock()
+
+	m.Lock()
+	return m.Unlock
+}
+
+// lockPartitions locks every partition chunk and spill hold posts for, see
+// Options.SerializePartitions, and returns the function unlocking them. They
+// are locked in order, so workers flushing overlapping partitions can't
+// deadlock.
+func (p *Processor) lockPartitions(chunk map[chunkKey][]Record, spill *chunkSpill) (unlock func()) {
+	var partitions []string
+	for key := range chunk {
+		partitions = append(partitions, key.partition)
+	}
+	if spill != nil {
+		for _, key := range spill.keys() {
+			partitions = append(partitions, key.partition)
+		}
+	}
+	slices.Sort(partitions)
+	partitions = slices.Compact(partitions)
+
+	unlocks := make([]func(), 0, len(partitions))
+	for _, partition := range partitions {
+		unlocks = append(unlocks, p.dirLocks.lock(partition))
+	}
+	return func() {
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}
+}
+
+// permissions are the modes files and directories below the output directory
+// are created with, before the umask.
+type permissions struct {
+	file, dir os.FileMode
+}
+
+var defaultPermissions = permissions{file: 0644, dir: 0755}
+
+func (p *Processor) perm() permissions {
+	return permissions{file: p.opts.FileMode, dir: p.opts.DirMode}
+}
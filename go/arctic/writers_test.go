@@ -0,0 +1,65 @@
+package arctic
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriterCacheEvictsOverLimit(t *testing.T) {
+	const limit, files, rounds = 4, 20, 3
+	dir := t.TempDir()
+	c := newWriterCache(limit, retryPolicy{attempts: 1}, defaultPermissions)
+	for round := range rounds {
+		for i := range files {
+			ow, err := c.get(filepath.Join(dir, fmt.Sprintf("%d.jsonl", i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := fmt.Fprintf(ow, "%d\n", round); err != nil {
+				t.Fatal(err)
+			}
+			if len(c.writers) > limit || c.lru.Len() != len(c.writers) {
+				t.Fatalf("%d writers open and %d in the LRU list, the limit is %d", len(c.writers), c.lru.Len(), limit)
+			}
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Evicted writers were flushed before they were closed
+	for i := range files {
+		data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%d.jsonl", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "0\n1\n2\n" {
+			t.Errorf("file %d holds %q, want every round", i, data)
+		}
+	}
+}
+
+func TestProcessFileMoreSubredditsThanOpenFiles(t *testing.T) {
+	opts := DefaultOptions()
+	opts.OutputCompression = CompressionNone
+	opts.MaxOpenFiles = 3
+	opts.ChunkBytes = 4 * 1024
+	p := newTestProcessor(t, opts)
+	const subreddits, rows = 50, 1000
+	var lines []string
+	for i := range rows {
+		lines = append(lines, post(fmt.Sprintf("sub%d", i%subreddits), fmt.Sprint(i), 1672531200+int64(i)))
+	}
+	path := writeDump(t, p.opts.InputDir, "RS_2023-01.zst", lines...)
+	if err := p.ProcessFile(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	for i := range subreddits {
+		if got := readLines(t, filepath.Join(p.opts.OutputDir, "2023-01", fmt.Sprintf("sub%d.jsonl", i))); len(got) != rows/subreddits {
+			t.Errorf("got %d posts in sub%d, want %d", len(got), i, rows/subreddits)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeFlag is a flag.Value accepting either an RFC3339 timestamp or Unix
+// seconds.
+type timeFlag struct {
+	time.Time
+}
+
+func (f *timeFlag) String() string {
+	if f.IsZero() {
+		return ""
+	}
+	return f.Format(time.RFC3339)
+}
+
+func (f *timeFlag) Set(value string) error {
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		f.Time = time.Unix(secs, 0).UTC()
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return fmt.Errorf("expected an RFC3339 timestamp or Unix seconds, got %q", value)
+	}
+	f.Time = t
+	return nil
+}
+
+// listFlag is a flag.Value collecting names. Each value is either a
+// comma-separated list or @ followed by the path to a file with one name per
+// line, so names that happen to be paths are never read as files; repeated
+// flags are merged.
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	if path, ok := strings.CutPrefix(value, "@"); ok {
+		return f.loadFile(path)
+	}
+	for _, name := range strings.Split(value, ",") {
+		f.add(name)
+	}
+	return nil
+}
+
+func (f *listFlag) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening list %s: %v", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f.add(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading list %s: %v", path, err)
+	}
+	return nil
+}
+
+func (f *listFlag) add(name string) {
+	if name = strings.TrimSpace(name); name != "" {
+		*f = append(*f, name)
+	}
+}
+
+// modeFlag is a flag.Value accepting octal permission bits, e.g. 0664.
+type modeFlag struct {
+	mode *os.FileMode
+}
+
+func (f modeFlag) String() string {
+	if f.mode == nil {
+		return ""
+	}
+	return fmt.Sprintf("%04o", uint32(*f.mode))
+}
+
+func (f modeFlag) Set(value string) error {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("expected octal permissions like 0644, got %q", value)
+	}
+	*f.mode = os.FileMode(mode)
+	return nil
+}
@@ -0,0 +1,448 @@
+// Command arctic organizes Reddit dump files into per-subreddit, per-month
+// compressed JSONL files. See package arctic for the pipeline itself.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"time"
+
+	"arctic_shift/arctic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// topSubredditsShown limits the per-subreddit table printed at the end; the
+// -stats-json output always contains every subreddit.
+const topSubredditsShown = 20
+
+// Variables
+var (
+	inputDir  = "D:/reddit/dumps/reddit/submissions"
+	outputDir = "D:/reddit/dumps/reddit/submissions/organized"
+
+	compressionLevel = "default"
+	statsJSONPath    string
+	distributionPath string
+	metricsAddr      string
+	anonymizeSalt    string
+	namePattern      string
+	monthPattern     string
+	filterExpr       string
+	verify           bool
+	verifyChecksums  bool
+	benchRows        int
+	gcPercent        int
+	mergePattern     string
+	mergeOutput      string
+	logLevel         = "info"
+	format           = string(arctic.FormatJSONL)
+	nameMode         = string(arctic.NamesASCIIOnly)
+	partition        = string(arctic.PartitionFile)
+	partitionKey     = string(arctic.PartitionBySubreddit)
+	dumpType         string
+	outputCompress   = string(arctic.CompressionZstd)
+	onExisting       = string(arctic.OnExistingOverwrite)
+	preserveMtime    string
+	recordDelimiter  string
+	sinkDir          string
+	noCompress       bool
+	columns          listFlag
+	deletedMarkers   listFlag
+	fields           listFlag
+
+	after, before    timeFlag
+	include, exclude listFlag
+	fileList         string
+	dictPath         string
+)
+
+// Main function
+func main() {
+	opts := arctic.DefaultOptions()
+
+	flag.StringVar(&inputDir, "input", inputDir, "directory containing the .zst dump files, a single .zst file, or - to read uncompressed JSONL from stdin")
+	flag.StringVar(&opts.Month, "month", "", "output directory name when reading from stdin, e.g. 2023-01")
+	flag.StringVar(&monthPattern, "month-pattern", "", "regexp valid months from dump names have to match, ^\\d{4}(-(0[1-9]|1[0-2]))?$ by default; others are written to _unknown")
+	flag.StringVar(&namePattern, "name-pattern", "", "regexp with a group named month to take the month from dump names, e.g. RS_(?P<month>\\d{4}-\\d{2})")
+	flag.StringVar(&outputDir, "output", outputDir, "directory the organized output is written to")
+	flag.IntVar(&opts.Concurrency, "concurrency", opts.Concurrency, "number of files processed concurrently")
+	flag.IntVar(&opts.CompressConcurrency, "compress-concurrency", opts.CompressConcurrency, "number of output files compressed concurrently")
+	flag.IntVar(&opts.WorkersPerFile, "workers-per-file", 1, "goroutines decoding the lines of each file")
+	flag.BoolVar(&opts.SerializePartitions, "serialize-partitions", false, "let only one file at a time write to a month, so files sharing one wait for each other instead of interleaving their writes")
+	flag.DurationVar(&opts.Timeout, "timeout", 0, "abort a file after it has been processing this long (0 disables)")
+	flag.BoolVar(&opts.FlushOnTimeout, "flush-on-timeout", false, "write the posts read before a file timed out instead of discarding them, and record it as partial in the progress manifest")
+	flag.BoolVar(&opts.Incremental, "incremental", false, "add new months to an existing output directory, skipping dumps whose month directory already exists")
+	flag.BoolVar(&opts.Force, "force", false, "reprocess files the progress manifest lists as completed")
+	flag.StringVar(&outputCompress, "output-compression", outputCompress, "final format of the output files: zstd, gzip or none")
+	flag.BoolVar(&noCompress, "no-compress", false, "leave the output uncompressed, same as -output-compression none")
+	flag.StringVar(&compressionLevel, "level", compressionLevel, "zstd compression level: fastest, default, better or best")
+	flag.IntVar(&opts.GzipLevel, "gzip-level", 0, "gzip compression level with -output-compression gzip, 1 (fastest) to 9 (smallest), 0 is gzip's default")
+	flag.StringVar(&dictPath, "dict", "", "zstd dictionary to compress the output with, e.g. from zstd --train; saved to the output directory as _zstd_dictionary")
+	flag.BoolVar(&opts.TrainDictionary, "train-dict", false, "train a zstd dictionary on the output and compress with it, which helps small files; saved as _zstd_dictionary")
+	flag.BoolVar(&opts.KeepJSONL, "keep-jsonl", false, "keep the uncompressed .jsonl/.csv files after compressing them")
+	flag.StringVar(&sinkDir, "sink-dir", "", "write the compressed files below this directory instead of the output directory, which keeps the working files")
+	flag.StringVar(&preserveMtime, "preserve-mtime", "", "set the modification time of the compressed files to that of input-file (the newest dump their posts came from), max-created-utc (their newest post) or now")
+	flag.StringVar(&onExisting, "on-existing", onExisting, "what to do with output files whose compressed version already exists: overwrite, skip or merge")
+	flag.Var(modeFlag{&opts.FileMode}, "file-mode", "permissions of the output files in octal, before the umask")
+	flag.Var(modeFlag{&opts.DirMode}, "dir-mode", "permissions of the output directories in octal, before the umask")
+	flag.BoolVar(&opts.SeekIndex, "seek-index", false, "compress jsonl output in ~1 MB frames and write a .idx file for reading single records")
+	flag.StringVar(&format, "format", format, "output format: jsonl or csv")
+	flag.Var(&columns, "columns", "CSV columns (comma-separated, or @file with one per line), default "+strings.Join(arctic.DefaultColumns, ","))
+	flag.StringVar(&anonymizeSalt, "anonymize-salt", "", "replace every author with a hash of the name keyed with this salt")
+	flag.Var(&fields, "fields", "only keep these JSON keys in jsonl output (comma-separated, or @file with one per line)")
+	flag.BoolVar(&opts.CompactJSON, "compact-json", false, "remove the whitespace between the tokens of every jsonl post instead of keeping it as in the input")
+	flag.StringVar(&mergePattern, "merge", "", "instead of processing, merge the output files of this subreddit, or of the subreddits matching a glob like 'Ask*', into one stream sorted by created_utc")
+	flag.StringVar(&mergeOutput, "merge-output", "", "file -merge writes to, compressed if it ends in .zst or .gz")
+	flag.IntVar(&benchRows, "bench", 0, "measure line processing and output writing on N synthetic posts instead of processing the input")
+	flag.StringVar(&statsJSONPath, "stats-json", "", "also write the run statistics to this JSON file")
+	flag.StringVar(&distributionPath, "distribution", "", "also write the rows per subreddit to this file, as JSON if it ends in .json, CSV otherwise")
+	flag.Int64Var(&opts.Limit, "limit", 0, "only read the first N lines of each file (0 reads all)")
+	flag.Int64Var(&opts.Sample, "sample", 0, "only keep every Kth line (0 or 1 keeps all)")
+	flag.BoolVar(&opts.FastDecode, "fast-decode", opts.FastDecode, "read the routing fields with a single-pass scanner instead of encoding/json")
+	flag.StringVar(&filterExpr, "filter", "", "only keep posts matching this expression, e.g. 'score > 100 && author != AutoModerator'; compare strings and booleans with == and !=, numbers also with <, <=, > and >=, combine with && and || and parentheses")
+	flag.BoolVar(&opts.Strict, "strict", false, "treat posts without a subreddit or a plausible numeric created_utc as unparseable")
+	flag.BoolVar(&opts.MoveCorrupt, "move-corrupt", false, "rename corrupt or truncated dumps to <name>.corrupt")
+	flag.Int64Var(&opts.MaxErrors, "max-errors", 0, "abort a file after this many unparseable lines (0 is unlimited)")
+	flag.IntVar(&opts.DecoderConcurrency, "decoder-concurrency", 0, "goroutines decoding each dump's zstd stream, 1 decodes synchronously (0 is 4 or the number of CPUs if fewer)")
+	flag.Uint64Var(&opts.DecoderMaxWindow, "decoder-max-window", 0, "largest zstd window accepted in bytes, dumps compressed with --long=31 need 2147483648 (0 is 512 MiB)")
+	flag.Uint64Var(&opts.DecoderMaxMemory, "decoder-max-memory", 0, "cap on the zstd window in bytes, for memory-constrained systems (0 is 64 GiB)")
+	flag.Int64Var(&opts.NotJSONLLines, "not-jsonl-lines", opts.NotJSONLLines, "abort a file as not JSONL when this many lines fail to parse before any parses (0 disables)")
+	flag.IntVar(&opts.MaxLineSize, "max-line-size", opts.MaxLineSize, "skip lines longer than this many bytes (0 is unlimited)")
+	flag.StringVar(&recordDelimiter, "record-delimiter", "", "what separates the records of the dumps: newline (the default), nul, a single character, or json to split concatenated JSON values, which may span lines")
+	flag.Float64Var(&opts.MaxReadMBps, "max-read-mbps", 0, "throttle the decompressed data each worker reads to this many MB/s (0 is unlimited)")
+	flag.Float64Var(&opts.MaxTotalReadMBps, "max-total-read-mbps", 0, "throttle the decompressed data all workers read together to this many MB/s (0 is unlimited)")
+	flag.Int64Var(&opts.ChunkBytes, "chunk-bytes", opts.ChunkBytes, "flush buffered posts once their JSON reaches this many bytes (0 flushes by count only)")
+	flag.Int64Var(&opts.MaxFileBytes, "max-file-bytes", 0, "start a new numbered part (<subreddit>.part0001.jsonl, ...) before an output file grows beyond this many uncompressed bytes (0 disables)")
+	flag.StringVar(&opts.TmpDir, "tmpdir", "", "move buffered posts to a temporary file in this directory once they reach -spill-bytes, instead of keeping a whole chunk in memory")
+	flag.Int64Var(&opts.SpillBytes, "spill-bytes", opts.SpillBytes, "buffered bytes before spilling to -tmpdir")
+	flag.IntVar(&opts.RetryAttempts, "retry-attempts", opts.RetryAttempts, "tries for opening and writing files that fail with transient errors (1 disables retrying)")
+	flag.DurationVar(&opts.RetryBackoff, "retry-backoff", opts.RetryBackoff, "wait before the first retry, doubled for every further one")
+	flag.IntVar(&opts.MaxOpenFiles, "max-open-files", opts.MaxOpenFiles, "output files each worker keeps open between chunks")
+	flag.BoolVar(&opts.Dedupe, "dedupe", false, "drop posts whose id was already seen in the same input file")
+	flag.IntVar(&opts.DedupeWindow, "dedupe-window", opts.DedupeWindow, "number of recent ids remembered by -dedupe")
+	flag.BoolVar(&opts.LowercaseSubreddits, "lowercase-subreddits", false, "write all casings of a subreddit name to one lowercase file")
+	flag.StringVar(&nameMode, "name-mode", nameMode, "subreddit file names: ascii-only drops other characters, unicode-safe percent-encodes them")
+	flag.BoolVar(&opts.SingleFile, "single-file", false, "write all posts of a month to one file, _all, instead of one per subreddit")
+	flag.BoolVar(&opts.SplitByType, "split-types", false, "write submissions and comments below separate submissions/ and comments/ directories")
+	flag.StringVar(&dumpType, "type", "", "only process dumps of this type: submissions (RS_) or comments (RC_)")
+	flag.BoolVar(&opts.DetectRecordType, "detect-type", false, "tell submissions and comments apart per record, for dumps that mix both (-type then filters records)")
+	flag.StringVar(&partitionKey, "partition-key", partitionKey, "field the output files are named after: subreddit, author or domain; posts of deleted authors go to _deleted")
+	flag.StringVar(&partition, "partition", partition, "output directories: file (month of the dump's name), or year, month or day of created_utc in UTC")
+	flag.BoolVar(&opts.FlatBySubreddit, "flat-by-subreddit", false, "write one file per subreddit across all months instead of one per month")
+	flag.BoolVar(&opts.AddSourceMonth, "add-source-month", false, "add the month of the dump to every post as source_month")
+	flag.IntVar(&opts.Shard, "shard", 0, "group output into subdirectories by the first 1 or 2 characters of the subreddit (0 disables)")
+	flag.StringVar(&opts.OutputTemplate, "output-template", "", "text/template for the path of every output file below the output directory, e.g. {{.Subreddit}}/{{.Year}}/{{.Month}}.jsonl, with .Subreddit, .Month, .Year and .Type")
+	flag.IntVar(&opts.MinPosts, "min-posts", 0, "drop subreddit files with fewer posts than this (0 keeps all)")
+	flag.BoolVar(&opts.PruneEmpty, "prune-empty", false, "remove the output directories this run wrote to that end up empty, e.g. after -min-posts")
+	flag.BoolVar(&opts.Sort, "sort", false, "sort every output file by created_utc (jsonl only)")
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "scan and filter without writing any output, then print the subreddit distribution")
+	flag.BoolVar(&opts.CountOnly, "count-only", false, "like -dry-run, but only count the posts per subreddit without buffering them, which is much faster")
+	flag.Var(&after, "after", "only keep posts created at or after this time (RFC3339 or Unix seconds)")
+	flag.Var(&before, "before", "only keep posts created before this time (RFC3339 or Unix seconds)")
+	flag.BoolVar(&opts.DropUndated, "drop-undated", false, "drop posts with a zero or missing created_utc")
+	flag.BoolVar(&opts.DropDeleted, "drop-deleted", false, "drop posts whose author, selftext or body is one of -deleted-markers")
+	flag.Var(&deletedMarkers, "deleted-markers", "values marking deleted posts for -drop-deleted (comma-separated, or @file with one per line), default "+strings.Join(arctic.DefaultDeletedMarkers, ","))
+	flag.StringVar(&fileList, "file-list", "", "text file with one .zst path per line to process instead of -input, e.g. a worker's share of a distributed run")
+	flag.Var(&include, "include", "only keep these subreddits (comma-separated, or @file with one per line)")
+	flag.Var(&exclude, "exclude", "drop these subreddits (comma-separated, or @file with one per line)")
+	flag.BoolVar(&opts.FileProgress, "file-progress", opts.FileProgress, "print a progress line per input file")
+	flag.BoolVar(&opts.RowEstimate, "row-estimate", false, "also show file progress in rows against an estimate of the total rows")
+	flag.StringVar(&opts.PauseFile, "pause-file", "", "pause the workers while a file exists at this path, e.g. touch it to pause and remove it to resume")
+	flag.BoolVar(&opts.RunReport, "run-report", false, "write the status, counters, timing and errors of every file to run-report.json in the output directory")
+	flag.BoolVar(&opts.ProfileTiming, "profile-timing", false, "print p50/p95/p99 of the time decoding a line and writing a chunk take at the end")
+	flag.BoolVar(&opts.TotalProgress, "total-progress", false, "print a single progress line for the whole run")
+	flag.DurationVar(&opts.ProgressInterval, "progress-interval", 0, "how often progress is updated (0 picks 100ms on a terminal and 10s when stdout is redirected)")
+	flag.BoolVar(&opts.Quiet, "quiet", false, "only print the final progress line of every file")
+	flag.BoolVar(&verifyChecksums, "verify-checksums", false, "instead of processing, check the output files against the SHA-256 checksums recorded in index.json")
+	flag.BoolVar(&verify, "verify", false, "check the output directory instead of processing: every file decompresses, every line is JSON and in its subreddit's file")
+	flag.IntVar(&gcPercent, "gc-percent", 0, "set the garbage collection target like GOGC: higher trades memory for speed, -1 disables the GC (0 keeps GOGC)")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "serve Prometheus metrics on this address during the run, e.g. :9090")
+	flag.StringVar(&logLevel, "log-level", logLevel, "log level: debug, info, warn or error")
+	flag.Parse()
+
+	// Logs go to stderr, the live progress lines to stdout
+	var slogLevel slog.Level
+	if err := slogLevel.UnmarshalText([]byte(logLevel)); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid log level %q: must be debug, info, warn or error\n", logLevel)
+		os.Exit(1)
+	}
+	logger := slog.New(slog.NewTextHandler(arctic.NewProgressAwareWriter(os.Stderr), &slog.HandlerOptions{Level: slogLevel}))
+	slog.SetDefault(logger)
+
+	if gcPercent != 0 {
+		debug.SetGCPercent(gcPercent)
+	}
+
+	ok, level := zstd.EncoderLevelFromString(compressionLevel)
+	if !ok {
+		logger.Error("invalid compression level, must be fastest, default, better or best", "level", compressionLevel)
+		os.Exit(1)
+	}
+
+	if namePattern != "" {
+		re, err := regexp.Compile(namePattern)
+		if err != nil {
+			logger.Error("invalid name pattern", "pattern", namePattern, "err", err)
+			os.Exit(1)
+		}
+		opts.NamePattern = re
+	}
+	if monthPattern != "" {
+		re, err := regexp.Compile(monthPattern)
+		if err != nil {
+			logger.Error("invalid month pattern", "pattern", monthPattern, "err", err)
+			os.Exit(1)
+		}
+		opts.MonthPattern = re
+	}
+	if filterExpr != "" {
+		filter, err := arctic.ParseFilter(filterExpr)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		opts.Filter = filter
+	}
+
+	opts.InputDir = inputDir
+	opts.OutputDir = outputDir
+	opts.CompressionLevel = level
+	opts.OutputCompression = arctic.Compression(outputCompress)
+	if noCompress {
+		opts.OutputCompression = arctic.CompressionNone
+	}
+	opts.After = after.Time
+	opts.Before = before.Time
+	opts.Include = include
+	if dictPath != "" {
+		dict, err := os.ReadFile(dictPath)
+		if err != nil {
+			logger.Error("error reading dictionary", "path", dictPath, "err", err)
+			os.Exit(1)
+		}
+		opts.Dictionary = dict
+	}
+	if fileList != "" {
+		var files listFlag
+		if err := files.loadFile(fileList); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		if len(files) == 0 {
+			logger.Error("the file list is empty", "path", fileList)
+			os.Exit(1)
+		}
+		opts.Files = files
+	}
+	opts.Exclude = exclude
+	opts.Format = arctic.OutputFormat(format)
+	opts.Columns = columns
+	opts.DryRun = opts.DryRun || opts.CountOnly
+	opts.DeletedMarkers = deletedMarkers
+	opts.Fields = fields
+	if anonymizeSalt != "" {
+		opts.Transformers = append(opts.Transformers, arctic.NewAuthorAnonymizer(anonymizeSalt))
+	}
+	opts.NameMode = arctic.NameMode(nameMode)
+	opts.Partition = arctic.Partition(partition)
+	opts.PartitionKey = arctic.PartitionKey(partitionKey)
+	opts.Type = arctic.DumpType(dumpType)
+	opts.OnExisting = arctic.ExistingPolicy(onExisting)
+	opts.PreserveMtime = arctic.MtimeSource(preserveMtime)
+	opts.RecordDelimiter = arctic.RecordDelimiter(recordDelimiter)
+	if sinkDir != "" {
+		opts.Sink = arctic.LocalSink{Dir: sinkDir, FileMode: opts.FileMode, DirMode: opts.DirMode}
+	}
+	opts.Logger = logger
+
+	// The first SIGINT/SIGTERM lets workers flush their chunks, a second one
+	// kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if verify || verifyChecksums {
+		os.Exit(verifyOutput(ctx, opts, verifyChecksums))
+	}
+	if mergePattern != "" {
+		os.Exit(merge(ctx, opts, mergePattern, mergeOutput))
+	}
+	if benchRows > 0 {
+		os.Exit(benchmark(ctx, opts, benchRows))
+	}
+
+	processor, err := arctic.NewProcessor(opts)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	if metricsAddr != "" {
+		stopMetrics, err := serveMetrics(metricsAddr, processor.Metrics())
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		defer stopMetrics()
+		logger.Info("serving metrics", "addr", metricsAddr, "path", "/metrics")
+	}
+
+	result, err := processor.Run(ctx)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+
+	switch {
+	case result.Cancelled:
+		logger.Warn("interrupted, skipping compression, the flushed .jsonl files are left in place",
+			"completed", result.Completed, "interrupted", result.Interrupted, "failed", result.Failed,
+			"skipped", result.Skipped, "not_started", result.NotStarted())
+	case opts.DryRun:
+		logger.Info("dry run complete, nothing was written")
+	}
+	if result.UnreadablePaths > 0 {
+		logger.Warn("some paths below the input directory couldn't be read and were skipped", "count", result.UnreadablePaths)
+	}
+
+	reportStats(processor.Stats(), opts.DryRun)
+	if timing := processor.Timing(); len(timing) > 0 {
+		fmt.Println()
+		arctic.PrintTiming(os.Stdout, timing)
+	}
+	if opts.Shard > 0 && !opts.DryRun {
+		layout := "<" + partition + ">/"
+		if opts.Partition == arctic.PartitionFile {
+			layout = "<month>/"
+		}
+		if opts.FlatBySubreddit {
+			layout = ""
+		}
+		fmt.Printf("\nOutput is sharded as %s<first %d characters of the lowercased subreddit>/<subreddit>\n", layout, opts.Shard)
+	}
+
+	if !result.Cancelled && !opts.DryRun {
+		fmt.Println("Done :>")
+	}
+}
+
+// verifyOutput checks the output directory, or only the checksums of its
+// files, and prints the violations found. It returns the exit code.
+func verifyOutput(ctx context.Context, opts arctic.Options, checksums bool) int {
+	verify := arctic.VerifyOutput
+	if checksums {
+		verify = arctic.VerifyChecksums
+	}
+	report, err := verify(ctx, opts)
+	if err != nil {
+		slog.Error(err.Error())
+		return 1
+	}
+	for _, v := range report.Violations {
+		if v.Line > 0 {
+			fmt.Printf("%s:%d: %s\n", v.File, v.Line, v.Problem)
+		} else {
+			fmt.Printf("%s: %s\n", v.File, v.Problem)
+		}
+	}
+	if checksums {
+		fmt.Printf("\nVerified the checksums of %d files: %d problems\n", report.Files, report.Failures)
+	} else {
+		fmt.Printf("\nVerified %d files with %d records: %d problems\n", report.Files, report.Records, report.Failures)
+	}
+	if report.Failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+// merge runs arctic.MergeSubreddits and returns the exit code.
+func merge(ctx context.Context, opts arctic.Options, pattern, output string) int {
+	if output == "" {
+		slog.Error("-merge needs -merge-output")
+		return 1
+	}
+	report, err := arctic.MergeSubreddits(ctx, opts, pattern, output)
+	if err != nil {
+		slog.Error(err.Error())
+		return 1
+	}
+	fmt.Printf("Merged %d files with %d records into %s\n", len(report.Files), report.Records, output)
+	return 0
+}
+
+// benchmark runs arctic.Benchmark and prints its throughput per stage. It
+// returns the exit code.
+func benchmark(ctx context.Context, opts arctic.Options, rows int) int {
+	results, err := arctic.Benchmark(ctx, opts, rows)
+	if err != nil {
+		slog.Error(err.Error())
+		return 1
+	}
+	for _, r := range results {
+		fmt.Printf("%-16s %10d rows %9.1f MB %10s %12.0f rows/s %8.1f MB/s\n",
+			r.Stage, r.Rows, float64(r.Bytes)/(1024*1024), r.Elapsed.Round(time.Millisecond), r.RowsPerSecond(), r.MBPerSecond())
+	}
+	return 0
+}
+
+// serveMetrics serves handler at /metrics on addr until the returned function
+// is called, which shuts the server down gracefully.
+func serveMetrics(addr string, handler http.Handler) (stop func(), err error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening for metrics on %s: %v", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics server failed", "err", err)
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("error shutting down metrics server", "err", err)
+		}
+		<-done
+	}, nil
+}
+
+func reportStats(stats *arctic.RunStats, dryRun bool) {
+	fmt.Println()
+	if dryRun {
+		stats.PrintSummary(os.Stdout, 0)
+	} else {
+		stats.PrintSummary(os.Stdout, topSubredditsShown)
+	}
+	if statsJSONPath != "" {
+		if err := stats.WriteJSON(statsJSONPath); err != nil {
+			slog.Error("error writing stats", "err", err)
+		}
+	}
+	if distributionPath != "" {
+		if err := stats.WriteDistribution(distributionPath); err != nil {
+			slog.Error("error writing distribution", "err", err)
+		}
+	}
+}
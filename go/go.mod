@@ -2,6 +2,9 @@ module arctic_shift
 
 go 1.23.0
 
-require github.com/klauspost/compress v1.17.9
+require (
+	github.com/klauspost/compress v1.17.9
+	golang.org/x/term v0.30.0
+)
 
-require golang.org/x/sync v0.8.0 // indirect
+require golang.org/x/sys v0.31.0 // indirect